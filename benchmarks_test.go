@@ -197,6 +197,34 @@ func BenchmarkDB_SequentialInsert(b *testing.B) {
 	b.ReportMetric(float64(numObjectsToInsert*b.N)/b.Elapsed().Seconds(), "objects/sec")
 }
 
+func BenchmarkDB_InsertMany(b *testing.B) {
+	objs := make([]testObject, numObjectsToInsert)
+	for i := range objs {
+		objs[i] = testObject{ID: uint64(i), Tags: nil}
+	}
+	var (
+		db    *DB
+		table RWTable[testObject]
+	)
+	b.ResetTimer()
+
+	for j := 0; j < b.N; j++ {
+		// Load into an empty table on each round.
+		b.StopTimer()
+		table, _ = NewTable("test", idIndex)
+		db, _ = NewDB([]TableMeta{table}, &NopMetrics{})
+		b.StartTimer()
+
+		txn := db.WriteTxn(table)
+		require.NoError(b, table.InsertMany(txn, FromSlice(objs)))
+		txn.Commit()
+	}
+	b.StopTimer()
+
+	require.EqualValues(b, table.NumObjects(db.ReadTxn()), numObjectsToInsert)
+	b.ReportMetric(float64(numObjectsToInsert*b.N)/b.Elapsed().Seconds(), "objects/sec")
+}
+
 func BenchmarkDB_Baseline_SingleRadix_Insert(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		tree := iradix.New[uint64]()
@@ -0,0 +1,128 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package statedb
+
+import (
+	"bytes"
+	"cmp"
+	"slices"
+
+	"github.com/cilium/statedb/index"
+)
+
+// keyedObject is an object with its key in an index.
+type keyedObject struct {
+	key index.Key
+	obj object
+}
+
+func compareKeyedObjects(a, b keyedObject) int {
+	return bytes.Compare(a.key, b.key)
+}
+
+// InsertMany inserts the objects into the table. If the table is empty the
+// objects are bulk loaded: each index is built by inserting the keys in sorted
+// order, which keeps the modified nodes in the writable node cache of the index
+// transaction, and without looking up and removing the keys of replaced objects.
+// Otherwise the objects are inserted one at a time as with Insert.
+func (txn *txn) InsertMany(meta TableMeta, objs []any) error {
+	if txn.db == nil {
+		return ErrTransactionClosed
+	}
+	tableName := meta.Name()
+	table := txn.modifiedTables[meta.tablePos()]
+	if table == nil {
+		return tableError(tableName, ErrTableNotLockedForWriting)
+	}
+	maxObjects, _ := meta.capacity()
+	if table.objectCount > 0 || (maxObjects > 0 && len(objs) > maxObjects) {
+		for _, data := range objs {
+			if _, _, err := txn.Insert(meta, Revision(0), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Assign the revisions in the given order and sort by primary key.
+	// Of the objects with the same primary key only the last one is kept
+	// as if they had been inserted one at a time.
+	primary := make([]keyedObject, len(objs))
+	for i, data := range objs {
+		obj := object{revision: table.revision + uint64(i) + 1, data: data}
+		primary[i] = keyedObject{meta.primary().fromObject(obj).First(), obj}
+	}
+	slices.SortStableFunc(primary, compareKeyedObjects)
+	n := 0
+	for i := range primary {
+		if i+1 < len(primary) && primary[i+1].key.Equal(primary[i].key) {
+			continue
+		}
+		primary[n] = primary[i]
+		n++
+	}
+	primary = primary[:n]
+
+	// Compute and sort the secondary keys and check the uniqueness of the
+	// unique indexes before modifying anything.
+	secondary := make(map[int][]keyedObject, len(table.secondary))
+	for _, indexer := range table.secondary {
+		var keys []keyedObject
+		for _, p := range primary {
+			indexer.fromObject(p.obj).Foreach(func(key index.Key) {
+				if !indexer.unique {
+					key = encodeNonUniqueKey(p.key, key)
+				}
+				keys = append(keys, keyedObject{key, p.obj})
+			})
+		}
+		slices.SortFunc(keys, compareKeyedObjects)
+		if indexer.unique {
+			for i := 1; i < len(keys); i++ {
+				if keys[i].key.Equal(keys[i-1].key) {
+					return uniqueConstraintError(tableName, indexer.name, keys[i].key)
+				}
+			}
+		}
+		secondary[indexer.pos] = keys
+	}
+
+	primaryTxn := txn.mustIndexWriteTxn(meta, PrimaryIndexPos)
+	for _, p := range primary {
+		primaryTxn.Insert(p.key, p.obj)
+	}
+
+	// Remove the older deleted objects with the same primary keys from the
+	// graveyard.
+	if txn.hasDeleteTrackers(meta) || table.hasDeletedObjects() {
+		graveyardTxn := txn.mustIndexWriteTxn(meta, GraveyardIndexPos)
+		graveyardRevisionTxn := txn.mustIndexWriteTxn(meta, GraveyardRevisionIndexPos)
+		for _, p := range primary {
+			if old, existed := graveyardTxn.Delete(p.key); existed {
+				graveyardRevisionTxn.Delete([]byte(index.Uint64(old.revision)))
+			}
+		}
+	}
+
+	for pos, keys := range secondary {
+		indexTxn := txn.mustIndexWriteTxn(meta, pos)
+		for _, k := range keys {
+			indexTxn.Insert(k.key, k.obj)
+		}
+	}
+
+	// Insert into the revision index and record the changes in revision order.
+	slices.SortFunc(primary, func(a, b keyedObject) int {
+		return cmp.Compare(a.obj.revision, b.obj.revision)
+	})
+	revIndexTxn := txn.mustIndexWriteTxn(meta, RevisionIndexPos)
+	for _, p := range primary {
+		revIndexTxn.Insert(index.Uint64(p.obj.revision), p.obj)
+		txn.recordChange(change{meta: meta, obj: p.obj})
+	}
+
+	table.revision += uint64(len(objs))
+	table.objectCount = len(primary)
+	return nil
+}
@@ -0,0 +1,78 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package statedb
+
+import (
+	"fmt"
+
+	"github.com/cilium/statedb/index"
+)
+
+// EvictionPolicy specifies what happens when a new object is inserted into
+// a table that is at its capacity. See WithCapacity.
+type EvictionPolicy struct {
+	// index is the name of the index whose first object is evicted. If
+	// empty the insert is rejected.
+	index IndexName
+}
+
+var (
+	// RejectWhenFull fails the insert with ErrTableFull.
+	RejectWhenFull = EvictionPolicy{}
+
+	// EvictOldest evicts the object that has gone the longest without
+	// being modified, e.g. the object with the lowest revision.
+	EvictOldest = EvictionPolicy{index: RevisionIndex}
+)
+
+// EvictByIndex evicts the first object in the order of the given index.
+// The objects not indexed by it are not evicted. If the index is empty
+// the insert fails with ErrTableFull.
+func EvictByIndex(name IndexName) EvictionPolicy {
+	return EvictionPolicy{index: name}
+}
+
+func (p EvictionPolicy) String() string {
+	switch p.index {
+	case "":
+		return "reject"
+	case RevisionIndex:
+		return "oldest"
+	}
+	return fmt.Sprintf("index(%s)", p.index)
+}
+
+// ensureCapacity makes room for inserting the new object with the given
+// primary key if the table is at its capacity, either by evicting another
+// object or by failing with ErrTableFull. Returns true if an object was evicted.
+func (txn *txn) ensureCapacity(meta TableMeta, table *tableEntry, idKey index.Key) (bool, error) {
+	maxObjects, policy := meta.capacity()
+	if maxObjects <= 0 || table.objectCount < maxObjects {
+		return false, nil
+	}
+
+	tableName := meta.Name()
+	if policy.index == "" {
+		txn.db.metrics.CapacityRejection(tableName)
+		return false, tableError(tableName, ErrTableFull)
+	}
+	pos, ok := table.indexPos(policy.index)
+	if !ok {
+		return false, tableError(tableName, fmt.Errorf("eviction index %q: %w", policy.index, ErrIndexNotFound))
+	}
+	iter := txn.mustIndexWriteTxn(meta, pos).Root().Iterator()
+	for _, victim, ok := iter.Next(); ok; _, victim, ok = iter.Next() {
+		if meta.primary().fromObject(victim).First().Equal(idKey) {
+			// The new object is already in the primary index.
+			continue
+		}
+		if _, _, err := txn.Delete(meta, Revision(0), victim.data); err != nil {
+			return false, err
+		}
+		txn.db.metrics.CapacityEviction(tableName)
+		return true, nil
+	}
+	txn.db.metrics.CapacityRejection(tableName)
+	return false, tableError(tableName, ErrTableFull)
+}
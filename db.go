@@ -6,6 +6,7 @@ package statedb
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"runtime"
 	"slices"
@@ -14,6 +15,8 @@ import (
 	"sync/atomic"
 	"time"
 
+	iradix "github.com/hashicorp/go-immutable-radix/v2"
+
 	"github.com/cilium/hive/cell"
 	"github.com/cilium/statedb/internal"
 )
@@ -85,22 +88,93 @@ import (
 //     the lowest revision of all delete trackers.
 type DB struct {
 	mu                  sync.Mutex // protects 'tables' and sequences modifications to the root tree
+	fkMu                sync.Mutex // sequences the commits that check foreign keys
 	ctx                 context.Context
 	cancel              context.CancelFunc
-	root                atomic.Pointer[dbRoot]
+	state               atomic.Pointer[dbState]
 	gcTrigger           chan struct{} // trigger for graveyard garbage collection
 	gcExited            chan struct{}
 	gcRateLimitInterval time.Duration
-	metrics             Metrics
+	expiryExited        chan struct{}
+	expiryInterval      time.Duration
+	expiryBackoffs      map[TableMeta]expiryBackoff // accessed only by the expiry worker
+	metrics             dbMetrics
 	defaultHandle       Handle
+	journal             *Journal
+	history             history // protected by 'mu'
+	watchdog            lockWatchdog
+
+	// forked is true for a database created with Fork. Its write transactions
+	// do not close the watch channels as the nodes of the trees are shared
+	// with the original database, which closes them.
+	forked bool
+
+	// tableMutexes are the table locks of a forked database. If nil the
+	// locks of the tables are used.
+	tableMutexes map[TableMeta]internal.SortableMutex
 }
 
+// dbState is the committed state of the database. It is replaced atomically
+// by Commit() and when tables are registered or unregistered.
+type dbState struct {
+	root dbRoot
+
+	// commitID is the number of write transactions committed to the
+	// database. See ReadTxn.CommitID.
+	commitID uint64
+
+	// committed is closed when the next write transaction is committed.
+	// Shared by the states that have the same commit ID.
+	committed chan struct{}
+
+	// foreignKeys are the foreign key relations between the tables.
+	// See AddForeignKey.
+	foreignKeys []*foreignKey
+}
+
+// withRoot returns a copy of the state with a modified root, e.g. after
+// registering a table.
+func (s *dbState) withRoot(root dbRoot) *dbState {
+	return &dbState{root: root, commitID: s.commitID, committed: s.committed, foreignKeys: s.foreignKeys}
+}
+
+// dbRoot is the root of the database holding the tables. The slots of
+// unregistered tables have a nil 'meta' and are reused when registering
+// new tables.
 type dbRoot []tableEntry
 
+// tables returns the registered tables.
+func (root dbRoot) tables() []TableMeta {
+	tables := make([]TableMeta, 0, len(root))
+	for _, table := range root {
+		if table.meta != nil {
+			tables = append(tables, table.meta)
+		}
+	}
+	return tables
+}
+
+// isRegistered returns true if the table is registered in this root.
+func (root dbRoot) isRegistered(table TableMeta) bool {
+	pos := table.tablePos()
+	return pos < len(root) && root[pos].meta == table
+}
+
+// tableMeta returns the table with the given name or nil if not found.
+func (root dbRoot) tableMeta(name TableName) TableMeta {
+	for _, table := range root {
+		if table.meta != nil && table.meta.Name() == name {
+			return table.meta
+		}
+	}
+	return nil
+}
+
 func NewDB(tables []TableMeta, metrics Metrics) (*DB, error) {
 	db := &DB{
-		metrics:             metrics,
+		metrics:             newDBMetrics(metrics),
 		gcRateLimitInterval: defaultGCRateLimitInterval,
+		expiryInterval:      defaultExpiryInterval,
 	}
 	db.defaultHandle = Handle{db, "DB"}
 	root := make(dbRoot, 0, len(tables))
@@ -109,11 +183,62 @@ func NewDB(tables []TableMeta, metrics Metrics) (*DB, error) {
 			return nil, err
 		}
 	}
-	db.root.Store(&root)
+	db.state.Store(&dbState{root: root, committed: make(chan struct{})})
 
 	return db, nil
 }
 
+// Fork returns a new database that starts from the current committed state
+// of this database, but is otherwise independent of it: it has its own table
+// locks, revisions and commit IDs and writes to either database are not seen
+// by the other. As the state is immutable forking is cheap and the objects
+// are shared until modified. This is useful for speculatively applying
+// changes and evaluating them.
+//
+// The fork has no delete trackers, history, journal, lock watchdog or metrics
+// and is not started, e.g. the graveyard is not garbage collected unless
+// Start is called. A table registered after forking must not be registered
+// to both databases.
+//
+// The watch channels returned by queries against the fork are not closed by
+// the writes to the fork, but may be closed by the writes to the original
+// database, as the unmodified parts of the trees are shared with it.
+func (db *DB) Fork() *DB {
+	state := db.state.Load()
+	fork := &DB{
+		metrics:             newDBMetrics(&NopMetrics{}),
+		gcRateLimitInterval: db.gcRateLimitInterval,
+		expiryInterval:      db.expiryInterval,
+		forked:              true,
+		tableMutexes:        map[TableMeta]internal.SortableMutex{},
+	}
+	fork.defaultHandle = Handle{fork, "DB"}
+
+	// The delete trackers belong to the original database.
+	root := slices.Clone(state.root)
+	for pos := range root {
+		if root[pos].meta != nil {
+			root[pos].deleteTrackers = iradix.New[deleteTracker]()
+			fork.tableMutexes[root[pos].meta] = internal.NewSortableMutex()
+		}
+	}
+	fork.state.Store(&dbState{
+		root:        root,
+		commitID:    state.commitID,
+		committed:   make(chan struct{}),
+		foreignKeys: state.foreignKeys,
+	})
+	return fork
+}
+
+// tableMutex returns the lock for writing to the table.
+func (db *DB) tableMutex(table TableMeta) internal.SortableMutex {
+	if smu, ok := db.tableMutexes[table]; ok {
+		return smu
+	}
+	return table.sortableMutex()
+}
+
 // RegisterTable registers a table to the database:
 //
 //	func NewMyTable() statedb.RWTable[MyTable] { ... }
@@ -128,7 +253,8 @@ func (db *DB) RegisterTable(table TableMeta, tables ...TableMeta) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	root := slices.Clone(*db.root.Load())
+	state := db.state.Load()
+	root := slices.Clone(state.root)
 
 	if err := db.registerTable(table, &root); err != nil {
 		return err
@@ -138,15 +264,22 @@ func (db *DB) RegisterTable(table TableMeta, tables ...TableMeta) error {
 			return err
 		}
 	}
-	db.root.Store(&root)
+	db.state.Store(state.withRoot(root))
 	return nil
 }
 
 func (db *DB) registerTable(table TableMeta, root *dbRoot) error {
 	name := table.Name()
-	for _, t := range *root {
-		if t.meta.Name() == name {
-			return tableError(name, ErrDuplicateTable)
+	if root.tableMeta(name) != nil {
+		return tableError(name, ErrDuplicateTable)
+	}
+
+	// Reuse the slot of a table that has been unregistered.
+	for pos := range *root {
+		if (*root)[pos].meta == nil {
+			table.setTablePos(pos)
+			(*root)[pos] = table.tableEntry()
+			return nil
 		}
 	}
 
@@ -156,6 +289,74 @@ func (db *DB) registerTable(table TableMeta, root *dbRoot) error {
 	return nil
 }
 
+// UnregisterTable removes the table and all of its objects from the database.
+// Fails if the table has delete trackers that have not been closed or if the
+// table is referenced by a foreign key of another table. The foreign keys of
+// the table itself are dropped. Read transactions created prior to unregistering
+// the table can still be used to query the table, but the watch channels of the
+// queries against it are closed.
+//
+// The table may be registered again, but it will then be empty.
+func (db *DB) UnregisterTable(table TableMeta) error {
+	// Lock the table to wait for the current write transaction against it
+	// to finish.
+	smu := db.tableMutex(table)
+	smu.Lock()
+	defer smu.Unlock()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	state := db.state.Load()
+	root := slices.Clone(state.root)
+	if !root.isRegistered(table) {
+		return tableError(table.Name(), ErrTableNotRegistered)
+	}
+	pos := table.tablePos()
+	if root[pos].deleteTrackers.Len() > 0 {
+		return tableError(table.Name(), ErrTableHasDeleteTrackers)
+	}
+	for _, fk := range state.foreignKeys {
+		if fk.parent == table && fk.child != table {
+			return tableError(table.Name(), fmt.Errorf("foreign key %s: %w", fk, ErrTableReferenced))
+		}
+	}
+
+	// Empty out the indexes to close the watch channels of the queries made
+	// against the table. The old trees are not modified and thus older read
+	// transactions are unaffected.
+	var txnToNotify []*iradix.Txn[object]
+	for _, entry := range root[pos].indexes {
+		itxn := entry.tree.Txn()
+		itxn.TrackMutate(!db.forked)
+		itxn.DeletePrefix(nil)
+		itxn.CommitOnly()
+		txnToNotify = append(txnToNotify, itxn)
+	}
+
+	// Leave the slot empty rather than removing it to not change the
+	// positions of the other tables.
+	root[pos] = tableEntry{}
+
+	// Drop the foreign keys of the table.
+	newState := &dbState{
+		root:      root,
+		commitID:  state.commitID + 1,
+		committed: make(chan struct{}),
+		foreignKeys: slices.DeleteFunc(slices.Clone(state.foreignKeys), func(fk *foreignKey) bool {
+			return fk.child == table
+		}),
+	}
+	db.state.Store(newState)
+	db.recordHistory(newState, 0)
+
+	for _, itxn := range txnToNotify {
+		itxn.Notify()
+	}
+	close(state.committed)
+	return nil
+}
+
 // ReadTxn constructs a new read transaction for performing reads against
 // a snapshot of the database.
 //
@@ -164,6 +365,31 @@ func (db *DB) ReadTxn() ReadTxn {
 	return db.defaultHandle.ReadTxn()
 }
 
+// WriteTxnContext constructs a new write transaction against the given set of
+// tables like WriteTxn, but gives up on acquiring the table locks when the
+// context is cancelled and returns the context's error.
+func (db *DB) WriteTxnContext(ctx context.Context, table TableMeta, tables ...TableMeta) (WriteTxn, error) {
+	return db.defaultHandle.WriteTxnContext(ctx, table, tables...)
+}
+
+// WaitForCommit blocks until the write transaction with the given commit ID
+// has been committed and returns a read transaction at that commit or newer.
+// Returns the context's error if it is cancelled before then. See
+// ReadTxn.CommitID.
+func (db *DB) WaitForCommit(ctx context.Context, commitID uint64) (ReadTxn, error) {
+	for {
+		state := db.state.Load()
+		if state.commitID >= commitID {
+			return newReadTxn(db, state), nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-state.committed:
+		}
+	}
+}
+
 // WriteTxn constructs a new write transaction against the given set of tables.
 // Each table is locked, which may block until the table locks are acquired.
 // The modifications performed in the write transaction are not visible outside
@@ -179,6 +405,12 @@ func (db *DB) Start(cell.HookContext) error {
 	db.gcExited = make(chan struct{})
 	db.ctx, db.cancel = context.WithCancel(context.Background())
 	go graveyardWorker(db, db.ctx, db.gcRateLimitInterval)
+	db.expiryExited = make(chan struct{})
+	go expiryWorker(db, db.ctx, db.expiryInterval)
+	if db.watchdog.enabled() {
+		db.watchdog.exited = make(chan struct{})
+		go lockWatchdogWorker(db, db.ctx)
+	}
 	return nil
 }
 
@@ -189,6 +421,18 @@ func (db *DB) Stop(stopCtx cell.HookContext) error {
 		return errors.New("timed out waiting for graveyard worker to exit")
 	case <-db.gcExited:
 	}
+	select {
+	case <-stopCtx.Done():
+		return errors.New("timed out waiting for expiry worker to exit")
+	case <-db.expiryExited:
+	}
+	if db.watchdog.exited != nil {
+		select {
+		case <-stopCtx.Done():
+			return errors.New("timed out waiting for lock watchdog to exit")
+		case <-db.watchdog.exited:
+		}
+	}
 	return nil
 }
 
@@ -206,12 +450,25 @@ func (db *DB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	db.ReadTxn().WriteJSON(w)
 }
 
+// SetJournal sets the journal to which the changes made by committed write
+// transactions are appended. Must be called before the database is modified.
+// See Journal.
+func (db *DB) SetJournal(j *Journal) {
+	db.journal = j
+}
+
 // setGCRateLimitInterval can set the graveyard GC interval before DB is started.
 // Used by tests.
 func (db *DB) setGCRateLimitInterval(interval time.Duration) {
 	db.gcRateLimitInterval = interval
 }
 
+// setExpiryInterval sets the interval at which expired objects are deleted.
+// Must be called before DB is started. Used by tests.
+func (db *DB) setExpiryInterval(interval time.Duration) {
+	db.expiryInterval = interval
+}
+
 // NewHandle returns a named handle to the DB. The handle has the same ReadTxn and
 // WriteTxn methods as DB, but annotated with the given name for more accurate
 // cost accounting in e.g. metrics.
@@ -227,20 +484,57 @@ type Handle struct {
 }
 
 func (h Handle) WriteTxn(table TableMeta, tables ...TableMeta) WriteTxn {
-	db := h.db
 	allTables := append(tables, table)
 	smus := internal.SortableMutexes{}
 	for _, table := range allTables {
-		smus = append(smus, table.sortableMutex())
+		smus = append(smus, h.db.tableMutex(table))
 	}
 	lockAt := time.Now()
 	smus.Lock()
+	txn, err := h.newWriteTxn(allTables, smus, lockAt)
+	if err != nil {
+		panic(err)
+	}
+	return txn
+}
+
+// WriteTxnContext is WriteTxn that gives up on acquiring the table locks when
+// the context is cancelled. The locks acquired so far are then released and
+// the context's error is returned. Fails with ErrTableNotRegistered if any of
+// the tables is not registered.
+func (h Handle) WriteTxnContext(ctx context.Context, table TableMeta, tables ...TableMeta) (WriteTxn, error) {
+	allTables := append(tables, table)
+	smus := internal.SortableMutexes{}
+	for _, table := range allTables {
+		smus = append(smus, h.db.tableMutex(table))
+	}
+	lockAt := time.Now()
+	if err := smus.LockContext(ctx); err != nil {
+		return nil, err
+	}
+	txn, err := h.newWriteTxn(allTables, smus, lockAt)
+	if err != nil {
+		// Return a nil interface rather than one holding a nil *txn.
+		return nil, err
+	}
+	return txn, nil
+}
+
+// newWriteTxn constructs the write transaction after the table locks have
+// been acquired. On failure the locks are released.
+func (h Handle) newWriteTxn(allTables []TableMeta, smus internal.SortableMutexes, lockAt time.Time) (*txn, error) {
+	db := h.db
 	acquiredAt := time.Now()
 
-	root := *db.root.Load()
+	state := db.state.Load()
+	root := state.root
 	tableEntries := make([]*tableEntry, len(root))
 	var tableNames []string
 	for _, table := range allTables {
+		if !root.isRegistered(table) {
+			smus.Unlock()
+			return nil, tableError(table.Name(), ErrTableNotRegistered)
+		}
 		tableEntry := root[table.tablePos()]
 		tableEntry.indexes = slices.Clone(tableEntry.indexes)
 		tableEntries[table.tablePos()] = &tableEntry
@@ -249,7 +543,7 @@ func (h Handle) WriteTxn(table TableMeta, tables ...TableMeta) WriteTxn {
 		db.metrics.WriteTxnTableAcquisition(
 			h.name,
 			table.Name(),
-			table.sortableMutex().AcquireDuration(),
+			db.tableMutex(table).AcquireDuration(),
 		)
 	}
 
@@ -265,14 +559,19 @@ func (h Handle) WriteTxn(table TableMeta, tables ...TableMeta) WriteTxn {
 	txn := &txn{
 		db:             db,
 		root:           root,
+		commitID:       state.commitID,
 		modifiedTables: tableEntries,
 		smus:           smus,
 		acquiredAt:     acquiredAt,
 		tableNames:     tableNames,
 		handle:         h.name,
+		journal:        db.journal,
+		foreignKeys:    state.foreignKeys,
 	}
+	txn.recordChanges = txn.journal != nil || txn.hasForeignKeys(txn.foreignKeys)
 	runtime.SetFinalizer(txn, txnFinalizer)
-	return txn
+	db.watchdog.acquired(txn)
+	return txn, nil
 }
 
 // ReadTxn constructs a new read transaction for performing reads against
@@ -280,8 +579,5 @@ func (h Handle) WriteTxn(table TableMeta, tables ...TableMeta) WriteTxn {
 //
 // The returned ReadTxn is not thread-safe.
 func (h Handle) ReadTxn() ReadTxn {
-	return &txn{
-		db:   h.db,
-		root: *h.db.root.Load(),
-	}
+	return newReadTxn(h.db, h.db.state.Load())
 }
@@ -10,7 +10,9 @@ import (
 	"expvar"
 	"fmt"
 	"log/slog"
+	"math"
 	"os"
+	"slices"
 	"testing"
 	"time"
 
@@ -207,6 +209,140 @@ func TestDB_LowerBound_ByRevision(t *testing.T) {
 	require.False(t, ok)
 }
 
+func TestDB_Range(t *testing.T) {
+	t.Parallel()
+
+	db, table := newTestDBWithMetrics(t, &NopMetrics{}, tagsIndex)
+
+	wtxn := db.WriteTxn(table)
+	for id := uint64(0); id < 3000; id++ {
+		table.Insert(wtxn, testObject{ID: id})
+	}
+	wtxn.Commit()
+
+	txn := db.ReadTxn()
+	iter, watch := table.Range(txn, idIndex.Query(1000), idIndex.Query(1010))
+	ids := objectIDs(Collect(iter))
+	require.Len(t, ids, 10)
+	require.Equal(t, uint64(1000), ids[0])
+	require.Equal(t, uint64(1009), ids[9])
+
+	iter, _ = table.Range(txn, idIndex.Query(1010), idIndex.Query(1000))
+	require.Empty(t, Collect(iter))
+
+	// Changes outside the range do not close the watch channel.
+	wtxn = db.WriteTxn(table)
+	table.Insert(wtxn, testObject{ID: 2000, Tags: []string{"modified"}})
+	wtxn.Commit()
+	select {
+	case <-watch:
+		t.Fatalf("expected Range watch to not be closed by changes outside the range")
+	default:
+	}
+
+	wtxn = db.WriteTxn(table)
+	table.Insert(wtxn, testObject{ID: 1005, Tags: []string{"modified"}})
+	wtxn.Commit()
+	select {
+	case <-watch:
+	case <-time.After(watchCloseTimeout):
+		t.Fatalf("expected Range watch to close after changes in the range")
+	}
+
+	// Non-unique keys are compared without the primary key. Objects with large
+	// primary keys and a key that is a prefix of the upper bound sort after it.
+	const large = uint64(1) << 63
+	wtxn = db.WriteTxn(table)
+	table.DeleteAll(wtxn)
+	table.Insert(wtxn, testObject{ID: 1, Tags: []string{"a"}})
+	table.Insert(wtxn, testObject{ID: large, Tags: []string{"a"}})
+	table.Insert(wtxn, testObject{ID: large + 1, Tags: []string{"ab"}})
+	table.Insert(wtxn, testObject{ID: 2, Tags: []string{"abc"}})
+	table.Insert(wtxn, testObject{ID: 3, Tags: []string{"b"}})
+	table.Insert(wtxn, testObject{ID: large + 2, Tags: []string{""}})
+	wtxn.Commit()
+
+	txn = db.ReadTxn()
+	iter, _ = table.Range(txn, tagsIndex.Query("a"), tagsIndex.Query("abc"))
+	require.ElementsMatch(t, []uint64{1, large, large + 1}, objectIDs(Collect(iter)))
+	iter, _ = table.Range(txn, tagsIndex.Query("ab"), tagsIndex.Query("c"))
+	require.ElementsMatch(t, []uint64{large + 1, 2, 3}, objectIDs(Collect(iter)))
+	iter, _ = table.Range(txn, tagsIndex.Query(""), tagsIndex.Query("ab"))
+	require.ElementsMatch(t, []uint64{1, large, large + 2}, objectIDs(Collect(iter)))
+
+	require.Panics(t, func() {
+		table.Range(txn, idIndex.Query(1), tagsIndex.Query("a"))
+	})
+}
+
+func TestDB_Reverse(t *testing.T) {
+	t.Parallel()
+
+	db, table := newTestDBWithMetrics(t, &NopMetrics{}, tagsIndex)
+
+	wtxn := db.WriteTxn(table)
+	table.Insert(wtxn, testObject{ID: 1, Tags: []string{"a"}})
+	table.Insert(wtxn, testObject{ID: 2, Tags: []string{"a", "ab"}})
+	table.Insert(wtxn, testObject{ID: 3, Tags: []string{"b"}})
+	table.Insert(wtxn, testObject{ID: 4, Tags: []string{"a"}})
+	table.Insert(wtxn, testObject{ID: 300, Tags: []string{"ab"}})
+	wtxn.Commit()
+	txn := db.ReadTxn()
+
+	iter, _ := table.AllReverse(txn)
+	require.Equal(t, []uint64{300, 4, 3, 2, 1}, objectIDs(Collect(iter)))
+
+	iter, _ = table.GetReverse(txn, tagsIndex.Query("a"))
+	require.Equal(t, []uint64{4, 2, 1}, objectIDs(Collect(iter)))
+	iter, _ = table.GetReverse(txn, idIndex.Query(3))
+	require.Equal(t, []uint64{3}, objectIDs(Collect(iter)))
+	iter, _ = table.GetReverse(txn, idIndex.Query(5))
+	require.Empty(t, Collect(iter))
+
+	iter, _ = table.PrefixReverse(txn, tagsIndex.Query("a"))
+	require.Equal(t, []uint64{300, 2, 4, 2, 1}, objectIDs(Collect(iter)))
+
+	iter, _ = table.UpperBound(txn, idIndex.Query(3))
+	require.Equal(t, []uint64{3, 2, 1}, objectIDs(Collect(iter)))
+	iter, _ = table.UpperBound(txn, idIndex.Query(299))
+	require.Equal(t, []uint64{4, 3, 2, 1}, objectIDs(Collect(iter)))
+	iter, _ = table.UpperBound(txn, idIndex.Query(0))
+	require.Empty(t, Collect(iter))
+
+	// The objects with the secondary key equal to the query are included,
+	// but not the ones with a longer key.
+	iter, _ = table.UpperBound(txn, tagsIndex.Query("a"))
+	require.Equal(t, []uint64{4, 2, 1}, objectIDs(Collect(iter)))
+	iter, _ = table.UpperBound(txn, tagsIndex.Query("ab"))
+	require.Equal(t, []uint64{300, 2, 4, 2, 1}, objectIDs(Collect(iter)))
+
+	// Newest objects first.
+	iter, watch := table.UpperBound(txn, ByRevision[testObject](table.Revision(txn)))
+	require.Equal(t, []uint64{300, 4, 3, 2, 1}, objectIDs(Collect(iter)))
+
+	wtxn = db.WriteTxn(table)
+	table.Insert(wtxn, testObject{ID: 2})
+	wtxn.Commit()
+	select {
+	case <-watch:
+	case <-time.After(watchCloseTimeout):
+		t.Fatalf("expected UpperBound watch to close after changes")
+	}
+	iter, _ = table.UpperBound(db.ReadTxn(), ByRevision[testObject](math.MaxUint64))
+	require.Equal(t, []uint64{2, 300, 4, 3, 1}, objectIDs(Collect(iter)))
+
+	// With a large primary key an object with a lesser secondary key sorts
+	// after the objects with the query as the secondary key.
+	wtxn = db.WriteTxn(table)
+	table.Insert(wtxn, testObject{ID: 1 << 63, Tags: []string{"a"}})
+	wtxn.Commit()
+	txn = db.ReadTxn()
+	iter, _ = table.UpperBound(txn, tagsIndex.Query("a"))
+	require.Equal(t, []uint64{1 << 63, 4, 1}, objectIDs(Collect(iter)))
+	iter, _ = table.UpperBound(txn, tagsIndex.Query("ab"))
+	require.Equal(t, []uint64{1 << 63, 300, 4, 1}, objectIDs(Collect(iter)))
+}
+
 func TestDB_Prefix(t *testing.T) {
 	t.Parallel()
 
@@ -454,6 +590,99 @@ func TestDB_Observable(t *testing.T) {
 	require.False(t, ok, "expected channel to close, got event: %+v", ev)
 }
 
+func TestDB_DeleteWhere(t *testing.T) {
+	t.Parallel()
+
+	db, table, _ := newTestDB(t, tagsIndex)
+
+	wtxn := db.WriteTxn(table)
+	dt, err := table.DeleteTracker(wtxn, "test")
+	require.NoError(t, err)
+	defer dt.Close()
+	for id := uint64(1); id <= 10; id++ {
+		tags := []string{"odd"}
+		if id%2 == 0 {
+			tags = []string{"even"}
+		}
+		table.Insert(wtxn, testObject{ID: id, Tags: tags})
+	}
+	wtxn.Commit()
+
+	wtxn = db.WriteTxn(table)
+	numDeleted, err := table.DeleteWhere(wtxn, tagsIndex.Query("even"))
+	require.NoError(t, err)
+	require.Equal(t, 5, numDeleted)
+	numDeleted, err = table.DeleteWhere(wtxn, idIndex.Query(3))
+	require.NoError(t, err)
+	require.Equal(t, 1, numDeleted)
+	numDeleted, err = table.DeleteWhere(wtxn, tagsIndex.Query("even"))
+	require.NoError(t, err)
+	require.Zero(t, numDeleted)
+	_, err = table.DeleteWhere(wtxn, Query[testObject]{index: "nonexisting"})
+	require.ErrorIs(t, err, ErrIndexNotFound)
+	wtxn.Commit()
+
+	iter, _ := table.All(db.ReadTxn())
+	require.Equal(t, []uint64{1, 5, 7, 9}, objectIDs(Collect(iter)))
+
+	// The deleted objects are seen by the delete tracker in the order of deletion.
+	require.Equal(t, []uint64{2, 4, 6, 8, 10, 3}, objectIDs(Collect(dt.Deleted(db.ReadTxn(), 0))))
+
+	_, err = table.DeleteWhere(wtxn, tagsIndex.Query("odd"))
+	require.ErrorIs(t, err, ErrTransactionClosed)
+}
+
+func objectIDs(objs []testObject) []uint64 {
+	ids := make([]uint64, len(objs))
+	for i, obj := range objs {
+		ids[i] = obj.ID
+	}
+	return ids
+}
+
+func TestDB_Fork(t *testing.T) {
+	t.Parallel()
+
+	db, table, _ := newTestDB(t, tagsIndex)
+
+	wtxn := db.WriteTxn(table)
+	dt, err := table.DeleteTracker(wtxn, "test")
+	require.NoError(t, err)
+	defer dt.Close()
+	table.Insert(wtxn, testObject{ID: 1, Tags: []string{"a"}})
+	table.Insert(wtxn, testObject{ID: 2, Tags: []string{"a"}})
+	wtxn.Commit()
+	rev := table.Revision(db.ReadTxn())
+
+	fork := db.Fork()
+	require.Equal(t, db.ReadTxn().CommitID(), fork.ReadTxn().CommitID())
+
+	// The fork has its own locks.
+	wtxn = db.WriteTxn(table)
+	forkTxn := fork.WriteTxn(table)
+	table.Insert(forkTxn, testObject{ID: 3, Tags: []string{"b"}})
+	table.Delete(forkTxn, testObject{ID: 1})
+	forkTxn.Commit()
+	table.Insert(wtxn, testObject{ID: 4})
+	wtxn.Commit()
+
+	iter, _ := table.All(fork.ReadTxn())
+	require.Equal(t, []uint64{2, 3}, objectIDs(Collect(iter)))
+	iter, _ = table.Get(fork.ReadTxn(), tagsIndex.Query("b"))
+	require.Equal(t, []uint64{3}, objectIDs(Collect(iter)))
+	iter, _ = table.All(db.ReadTxn())
+	require.Equal(t, []uint64{1, 2, 4}, objectIDs(Collect(iter)))
+
+	// The revisions proceed independently.
+	require.Equal(t, rev+1, table.Revision(db.ReadTxn()))
+	require.Equal(t, rev+2, table.Revision(fork.ReadTxn()))
+
+	// The deletions in the fork are not seen by the delete trackers of
+	// the original.
+	require.Empty(t, Collect(dt.Deleted(db.ReadTxn(), 0)))
+	require.Empty(t, Collect(dt.Deleted(fork.ReadTxn(), 0)))
+}
+
 func TestDB_All(t *testing.T) {
 	t.Parallel()
 
@@ -705,6 +934,921 @@ func TestDB_CommitAbort(t *testing.T) {
 	require.Nil(t, obj.Tags, "expected no tags")
 }
 
+func TestDB_OnCommitOnAbort(t *testing.T) {
+	t.Parallel()
+
+	db, table, _ := newTestDB(t, tagsIndex)
+
+	var (
+		committed []ReadTxn
+		aborted   int
+	)
+
+	txn := db.WriteTxn(table)
+	txn.OnCommit(func(rtxn ReadTxn) {
+		committed = append(committed, rtxn)
+
+		// The table locks have been released and thus further writes
+		// can be made.
+		wtxn := db.WriteTxn(table)
+		table.Insert(wtxn, testObject{ID: 2})
+		wtxn.Commit()
+	})
+	txn.OnCommit(func(rtxn ReadTxn) {
+		committed = append(committed, rtxn)
+	})
+	txn.OnAbort(func() { aborted++ })
+	table.Insert(txn, testObject{ID: 1})
+	require.Empty(t, committed)
+	txn.Commit()
+	txn.Abort() // no-op
+
+	require.Len(t, committed, 2)
+	require.Zero(t, aborted)
+
+	// The hooks are given the snapshot right after the commit and thus do
+	// not observe the insert done in the first hook.
+	for _, rtxn := range committed {
+		_, _, found := table.First(rtxn, idIndex.Query(1))
+		require.True(t, found)
+		_, _, found = table.First(rtxn, idIndex.Query(2))
+		require.False(t, found)
+	}
+	_, _, found := table.First(db.ReadTxn(), idIndex.Query(2))
+	require.True(t, found)
+
+	// Registering after commit has no effect.
+	txn.OnCommit(func(ReadTxn) { t.Fatalf("OnCommit called after Commit") })
+
+	txn = db.WriteTxn(table)
+	txn.OnCommit(func(ReadTxn) { t.Fatalf("OnCommit called on Abort") })
+	txn.OnAbort(func() { aborted++ })
+	txn.Abort()
+	txn.Commit() // no-op
+	require.Equal(t, 1, aborted)
+}
+
+func TestDB_Savepoint(t *testing.T) {
+	t.Parallel()
+
+	db, table, _ := newTestDB(t, tagsIndex)
+
+	txn := db.WriteTxn(table)
+	table.Insert(txn, testObject{ID: 1, Tags: []string{"a"}})
+	table.Insert(txn, testObject{ID: 3, Tags: []string{"a"}})
+	txn.Commit()
+
+	rtxn := db.ReadTxn()
+	_, _, watch1, _ := table.FirstWatch(rtxn, idIndex.Query(1))
+	_, _, watch3, _ := table.FirstWatch(rtxn, idIndex.Query(3))
+
+	txn = db.WriteTxn(table)
+	table.Insert(txn, testObject{ID: 1, Tags: []string{"b"}})
+	sp := txn.Savepoint()
+	revAtSavepoint := table.Revision(txn)
+
+	table.Insert(txn, testObject{ID: 2, Tags: []string{"a"}})
+	table.Insert(txn, testObject{ID: 3, Tags: []string{"b"}})
+	table.Delete(txn, testObject{ID: 1})
+	txn.OnCommit(func(ReadTxn) { t.Fatalf("OnCommit registered after savepoint called") })
+
+	sp2 := txn.Savepoint()
+	table.Insert(txn, testObject{ID: 4})
+
+	iter, _ := table.All(txn)
+	require.Equal(t, []uint64{2, 3, 4}, Collect(Map(iter, testObject.getID)))
+
+	require.NoError(t, txn.RollbackTo(sp))
+	require.Equal(t, revAtSavepoint, table.Revision(txn))
+	iter, _ = table.All(txn)
+	require.Equal(t, []uint64{1, 3}, Collect(Map(iter, testObject.getID)))
+	iter, _ = table.Get(txn, tagsIndex.Query("b"))
+	require.Equal(t, []uint64{1}, Collect(Map(iter, testObject.getID)))
+
+	// Savepoints created after the one rolled back to are invalidated.
+	require.ErrorIs(t, txn.RollbackTo(sp2), ErrInvalidSavepoint)
+
+	// The savepoint can be rolled back to again.
+	table.Insert(txn, testObject{ID: 5})
+	require.NoError(t, txn.RollbackTo(sp))
+	table.Insert(txn, testObject{ID: 6})
+	txn.Commit()
+
+	require.ErrorIs(t, txn.RollbackTo(sp), ErrTransactionClosed)
+
+	rtxn = db.ReadTxn()
+	iter, _ = table.All(rtxn)
+	require.Equal(t, []uint64{1, 3, 6}, Collect(Map(iter, testObject.getID)))
+	obj, _, _ := table.First(rtxn, idIndex.Query(1))
+	require.Equal(t, []string{"b"}, obj.Tags)
+	iter, _ = table.Get(rtxn, tagsIndex.Query("a"))
+	require.Equal(t, []uint64{3}, Collect(Map(iter, testObject.getID)))
+
+	// Only the object modified prior to the savepoint is notified.
+	select {
+	case <-watch1:
+	case <-time.After(watchCloseTimeout):
+		t.Fatalf("expected watch channel of modified object to close")
+	}
+	select {
+	case <-watch3:
+		t.Fatalf("expected watch channel of rolled back object to not close")
+	default:
+	}
+
+	// Savepoint from another transaction is rejected.
+	txn = db.WriteTxn(table)
+	require.ErrorIs(t, txn.RollbackTo(sp), ErrInvalidSavepoint)
+	txn.Abort()
+}
+
+func TestDB_Changes(t *testing.T) {
+	t.Parallel()
+
+	db, table, _ := newTestDB(t, tagsIndex)
+
+	txn := db.WriteTxn(table)
+	table.Insert(txn, testObject{ID: 1, Tags: []string{"a"}})
+	table.Insert(txn, testObject{ID: 2, Tags: []string{"a"}})
+	table.Insert(txn, testObject{ID: 3, Tags: []string{"a"}})
+	txn.Commit()
+
+	txn = db.WriteTxn(table)
+	require.Empty(t, Collect(table.Changes(txn)))
+
+	table.Insert(txn, testObject{ID: 3, Tags: []string{"b"}})
+	table.Insert(txn, testObject{ID: 4, Tags: []string{"b"}})
+	table.Delete(txn, testObject{ID: 2})
+	table.Insert(txn, testObject{ID: 5})
+	table.Delete(txn, testObject{ID: 5})
+	table.Insert(txn, testObject{ID: 3, Tags: []string{"c"}})
+	table.Delete(txn, testObject{ID: 6}) // non-existing
+
+	iter := table.Changes(txn)
+	change, _, ok := iter.Next()
+	require.True(t, ok)
+	require.True(t, change.HadOld)
+	require.True(t, change.Deleted)
+	require.Equal(t, testObject{ID: 2, Tags: []string{"a"}}, change.Old)
+
+	change, rev, ok := iter.Next()
+	require.True(t, ok)
+	require.True(t, change.HadOld)
+	require.False(t, change.Deleted)
+	require.Equal(t, testObject{ID: 3, Tags: []string{"a"}}, change.Old)
+	require.Equal(t, testObject{ID: 3, Tags: []string{"c"}}, change.New)
+	_, rev3, _ := table.First(txn, idIndex.Query(3))
+	require.Equal(t, rev3, rev)
+
+	change, _, ok = iter.Next()
+	require.True(t, ok)
+	require.False(t, change.HadOld)
+	require.False(t, change.Deleted)
+	require.Equal(t, testObject{ID: 4, Tags: []string{"b"}}, change.New)
+
+	_, _, ok = iter.Next()
+	require.False(t, ok)
+
+	// Changes rolled back to a savepoint are not included.
+	sp := txn.Savepoint()
+	table.Insert(txn, testObject{ID: 7})
+	require.Len(t, Collect(table.Changes(txn)), 4)
+	require.NoError(t, txn.RollbackTo(sp))
+	require.Len(t, Collect(table.Changes(txn)), 3)
+
+	// Changes are not recorded for commit without a journal or foreign keys.
+	require.False(t, txn.getTxn().recordChanges)
+	require.Empty(t, txn.getTxn().changes)
+	txn.Abort()
+	require.Empty(t, Collect(table.Changes(txn)))
+}
+
+func TestDB_UnregisterTable(t *testing.T) {
+	t.Parallel()
+
+	db, table, _ := newTestDB(t, tagsIndex)
+	table2 := MustNewTable[testObject]("test2", idIndex)
+	table3 := MustNewTable[testObject]("test3", idIndex)
+	require.NoError(t, db.RegisterTable(table2))
+
+	txn := db.WriteTxn(table, table2)
+	table.Insert(txn, testObject{ID: 1})
+	table2.Insert(txn, testObject{ID: 2})
+	dt, err := table.DeleteTracker(txn, "test")
+	require.NoError(t, err)
+	txn.Commit()
+
+	// Cannot unregister a table with delete trackers.
+	require.ErrorIs(t, db.UnregisterTable(table), ErrTableHasDeleteTrackers)
+	dt.Close()
+
+	oldTxn := db.ReadTxn()
+	_, watchAll := table.All(oldTxn)
+	_, _, watchObj, _ := table.FirstWatch(oldTxn, idIndex.Query(1))
+	_, watchTags := table.Get(oldTxn, tagsIndex.Query("foo"))
+	require.NoError(t, db.UnregisterTable(table))
+	require.ErrorIs(t, db.UnregisterTable(table), ErrTableNotRegistered)
+
+	// Unregistering is a commit that closes the watch channels of the table.
+	require.Equal(t, oldTxn.CommitID()+1, db.ReadTxn().CommitID())
+	for _, watch := range []<-chan struct{}{watchAll, watchObj, watchTags} {
+		select {
+		case <-watch:
+		case <-time.After(watchCloseTimeout):
+			t.Fatalf("expected watch channel to close after UnregisterTable")
+		}
+	}
+
+	// The old read transaction can still be used to query the table.
+	_, _, found := table.First(oldTxn, idIndex.Query(1))
+	require.True(t, found)
+
+	// The table can no longer be written to or queried.
+	require.Panics(t, func() { db.WriteTxn(table) })
+	require.Panics(t, func() { table.First(db.ReadTxn(), idIndex.Query(1)) })
+
+	// Other tables are unaffected.
+	_, _, found = table2.First(db.ReadTxn(), idIndex.Query(2))
+	require.True(t, found)
+
+	// The slot is reused by the next registered table.
+	require.NoError(t, db.RegisterTable(table3))
+	require.Equal(t, table.tablePos(), table3.tablePos())
+	txn = db.WriteTxn(table3)
+	table3.Insert(txn, testObject{ID: 3})
+	txn.Commit()
+	require.Panics(t, func() { table3.First(oldTxn, idIndex.Query(3)) })
+	_, _, found = table.First(oldTxn, idIndex.Query(1))
+	require.True(t, found)
+
+	// The table can be registered again.
+	require.NoError(t, db.RegisterTable(table))
+	_, _, found = table.First(db.ReadTxn(), idIndex.Query(1))
+	require.False(t, found)
+
+	var buf bytes.Buffer
+	require.NoError(t, db.ReadTxn().WriteJSON(&buf))
+}
+
+func TestDB_AddDropIndex(t *testing.T) {
+	t.Parallel()
+
+	db, table, _ := newTestDB(t)
+
+	wtxn := db.WriteTxn(table)
+	table.Insert(wtxn, testObject{ID: 1, Tags: []string{"foo"}})
+	table.Insert(wtxn, testObject{ID: 2, Tags: []string{"foo", "bar"}})
+	wtxn.Commit()
+
+	oldTxn := db.ReadTxn()
+
+	wtxn = db.WriteTxn(table)
+	require.NoError(t, table.AddIndex(wtxn, tagsIndex))
+	require.ErrorIs(t, table.AddIndex(wtxn, tagsIndex), ErrDuplicateIndex)
+	require.ErrorIs(t, table.AddIndex(wtxn, idIndex), ErrDuplicateIndex)
+
+	// The new index can be queried within the write transaction.
+	iter, _ := table.Get(wtxn, tagsIndex.Query("foo"))
+	require.Len(t, Collect(iter), 2)
+	table.Insert(wtxn, testObject{ID: 3, Tags: []string{"bar"}})
+	iter, _ = table.Get(wtxn, tagsIndex.Query("bar"))
+	require.Len(t, Collect(iter), 2)
+
+	// Readers see the index only after commit.
+	require.Panics(t, func() { table.Get(db.ReadTxn(), tagsIndex.Query("foo")) })
+	wtxn.Commit()
+	require.Panics(t, func() { table.Get(oldTxn, tagsIndex.Query("foo")) })
+
+	txn := db.ReadTxn()
+	iter, watch := table.Get(txn, tagsIndex.Query("foo"))
+	require.Len(t, Collect(iter), 2)
+
+	// An aborted AddIndex leaves no trace.
+	wtxn = db.WriteTxn(table)
+	require.NoError(t, table.AddIndex(wtxn, Index[testObject, uint64]{
+		Name: "other",
+		FromObject: func(t testObject) index.KeySet {
+			return index.NewKeySet(index.Uint64(t.ID))
+		},
+		FromKey: index.Uint64,
+		Unique:  true,
+	}))
+	wtxn.Abort()
+	require.Panics(t, func() { table.Get(db.ReadTxn(), Query[testObject]{index: "other"}) })
+
+	// Dropping the index closes the watch channels.
+	wtxn = db.WriteTxn(table)
+	require.ErrorIs(t, table.DropIndex(wtxn, idIndex.Name), ErrIndexNotFound)
+	require.ErrorIs(t, table.DropIndex(wtxn, "nonexisting"), ErrIndexNotFound)
+	require.NoError(t, table.DropIndex(wtxn, tagsIndex.Name))
+	require.Panics(t, func() { table.Get(wtxn, tagsIndex.Query("foo")) })
+	wtxn.Commit()
+
+	select {
+	case <-watch:
+	case <-time.After(watchCloseTimeout):
+		t.Fatalf("watch channel not closed after DropIndex")
+	}
+	require.Panics(t, func() { table.Get(db.ReadTxn(), tagsIndex.Query("foo")) })
+
+	// The old read transaction still has the index.
+	iter, _ = table.Get(txn, tagsIndex.Query("foo"))
+	require.Len(t, Collect(iter), 2)
+
+	// Re-adding the index rebuilds it from the current objects.
+	wtxn = db.WriteTxn(table)
+	require.NoError(t, table.AddIndex(wtxn, tagsIndex))
+	table.Delete(wtxn, testObject{ID: 2})
+	wtxn.Commit()
+	iter, _ = table.Get(db.ReadTxn(), tagsIndex.Query("bar"))
+	require.Equal(t, []testObject{{ID: 3, Tags: []string{"bar"}}}, Collect(iter))
+}
+
+func TestDB_CommitID(t *testing.T) {
+	t.Parallel()
+
+	db, table, _ := newTestDB(t)
+	table2 := MustNewTable[testObject]("test2", idIndex)
+	require.NoError(t, db.RegisterTable(table2))
+
+	txn0 := db.ReadTxn()
+	require.Equal(t, uint64(0), txn0.CommitID())
+
+	type result struct {
+		txn ReadTxn
+		err error
+	}
+	waitResult := make(chan result, 1)
+	go func() {
+		txn, err := db.WaitForCommit(context.Background(), 2)
+		waitResult <- result{txn, err}
+	}()
+
+	wtxn := db.WriteTxn(table)
+	require.Equal(t, uint64(0), wtxn.CommitID())
+	table.Insert(wtxn, testObject{ID: 1})
+	wtxn.Commit()
+	require.Equal(t, uint64(1), db.ReadTxn().CommitID())
+
+	// Aborted transactions do not increment the commit ID.
+	wtxn = db.WriteTxn(table2)
+	table2.Insert(wtxn, testObject{ID: 1})
+	wtxn.Abort()
+	require.Equal(t, uint64(1), db.ReadTxn().CommitID())
+
+	select {
+	case <-waitResult:
+		t.Fatalf("WaitForCommit returned before commit 2")
+	default:
+	}
+
+	// The commit ID is shared by all tables.
+	wtxn = db.WriteTxn(table2)
+	table2.Insert(wtxn, testObject{ID: 2})
+	var hookCommitID uint64
+	wtxn.OnCommit(func(txn ReadTxn) { hookCommitID = txn.CommitID() })
+	wtxn.Commit()
+	require.Equal(t, uint64(2), hookCommitID)
+
+	select {
+	case res := <-waitResult:
+		require.NoError(t, res.err)
+		require.Equal(t, uint64(2), res.txn.CommitID())
+		_, _, found := table2.First(res.txn, idIndex.Query(2))
+		require.True(t, found)
+	case <-time.After(time.Second):
+		t.Fatalf("WaitForCommit did not return after commit 2")
+	}
+
+	// Waiting for an already committed ID returns immediately.
+	txn, err := db.WaitForCommit(context.Background(), 1)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), txn.CommitID())
+
+	// Registering tables does not change the commit ID.
+	require.NoError(t, db.RegisterTable(MustNewTable[testObject]("test3", idIndex)))
+	require.Equal(t, uint64(2), db.ReadTxn().CommitID())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = db.WaitForCommit(ctx, 3)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, uint64(0), txn0.CommitID())
+}
+
+func TestDB_WriteTxnContext(t *testing.T) {
+	t.Parallel()
+
+	db, table, _ := newTestDB(t)
+	table2 := MustNewTable[testObject]("test2", idIndex)
+	require.NoError(t, db.RegisterTable(table2))
+
+	wtxn := db.WriteTxn(table2)
+
+	// Acquiring the locks fails as table2 is locked. The lock on 'table'
+	// is released.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := db.WriteTxnContext(ctx, table, table2)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	wtxn2, err := db.WriteTxnContext(context.Background(), table)
+	require.NoError(t, err)
+	table.Insert(wtxn2, testObject{ID: 1})
+	wtxn2.Commit()
+	wtxn.Abort()
+
+	wtxn, err = db.NewHandle("test").WriteTxnContext(context.Background(), table, table2)
+	require.NoError(t, err)
+	wtxn.Commit()
+
+	wtxn, err = db.WriteTxnContext(context.Background(), MustNewTable[testObject]("unregistered", idIndex))
+	require.ErrorIs(t, err, ErrTableNotRegistered)
+	require.True(t, wtxn == nil, "expected nil WriteTxn on error")
+}
+
+func TestDB_UniqueConstraint(t *testing.T) {
+	t.Parallel()
+
+	// Index objects by their first tag, which is required to be unique.
+	firstTagIndex := Index[testObject, string]{
+		Name: "first-tag",
+		FromObject: func(t testObject) index.KeySet {
+			if len(t.Tags) == 0 {
+				return index.NewKeySet()
+			}
+			return index.NewKeySet(index.String(t.Tags[0]))
+		},
+		FromKey: index.String,
+		Unique:  true,
+	}
+	db, table, _ := newTestDB(t, firstTagIndex)
+
+	wtxn := db.WriteTxn(table)
+	defer wtxn.Abort()
+	_, _, err := table.Insert(wtxn, testObject{ID: 1, Tags: []string{"foo"}})
+	require.NoError(t, err)
+	revision := table.Revision(wtxn)
+
+	// Inserting an object with the same unique key fails and leaves
+	// the table untouched.
+	_, _, err = table.Insert(wtxn, testObject{ID: 2, Tags: []string{"foo"}})
+	require.ErrorIs(t, err, ErrUniqueConstraintViolation)
+	require.ErrorContains(t, err, `table "test": index "first-tag": key "foo"`)
+	require.Equal(t, revision, table.Revision(wtxn))
+	iter, _ := table.All(wtxn)
+	require.Len(t, Collect(iter), 1)
+	obj, _, found := table.First(wtxn, firstTagIndex.Query("foo"))
+	require.True(t, found)
+	require.Equal(t, uint64(1), obj.ID)
+
+	// Updating the object that holds the key is fine.
+	_, hadOld, err := table.Insert(wtxn, testObject{ID: 1, Tags: []string{"foo", "bar"}})
+	require.NoError(t, err)
+	require.True(t, hadOld)
+
+	// Once the key is released it can be taken by another object.
+	_, _, err = table.Insert(wtxn, testObject{ID: 1, Tags: []string{"bar"}})
+	require.NoError(t, err)
+	_, _, err = table.Insert(wtxn, testObject{ID: 2, Tags: []string{"foo"}})
+	require.NoError(t, err)
+	_, _, err = table.CompareAndSwap(wtxn, table.Revision(wtxn), testObject{ID: 2, Tags: []string{"bar"}})
+	require.ErrorIs(t, err, ErrUniqueConstraintViolation)
+	wtxn.Commit()
+
+	// Adding a unique index fails if the existing objects collide.
+	wtxn = db.WriteTxn(table)
+	table.Insert(wtxn, testObject{ID: 3, Tags: []string{"baz", "foo"}})
+	tagsUniqueIndex := tagsIndex
+	tagsUniqueIndex.Name = "tags-unique"
+	tagsUniqueIndex.Unique = true
+	err = table.AddIndex(wtxn, tagsUniqueIndex)
+	require.ErrorIs(t, err, ErrUniqueConstraintViolation)
+	require.ErrorContains(t, err, `key "foo"`)
+	require.Panics(t, func() { table.Get(wtxn, tagsUniqueIndex.Query("foo")) })
+	wtxn.Abort()
+}
+
+func TestDB_Validators(t *testing.T) {
+	t.Parallel()
+
+	errNoTags := errors.New("no tags")
+	table, err := NewTableWithOptions[testObject](
+		"test",
+		idIndex,
+		nil,
+		WithValidator(func(obj testObject) error {
+			if obj.ID == 0 {
+				return errors.New("zero ID")
+			}
+			return nil
+		}),
+		WithValidator(func(obj testObject) error {
+			if len(obj.Tags) == 0 {
+				return errNoTags
+			}
+			return nil
+		}),
+	)
+	require.NoError(t, err)
+	db, err := NewDB([]TableMeta{table}, NewExpVarMetrics(false))
+	require.NoError(t, err)
+
+	wtxn := db.WriteTxn(table)
+	defer wtxn.Abort()
+
+	_, _, err = table.Insert(wtxn, testObject{ID: 0, Tags: []string{"foo"}})
+	require.ErrorIs(t, err, ErrInvalidObject)
+	require.ErrorContains(t, err, "zero ID")
+
+	_, _, err = table.Insert(wtxn, testObject{ID: 1})
+	require.ErrorIs(t, err, ErrInvalidObject)
+	require.ErrorIs(t, err, errNoTags)
+
+	_, _, err = table.Insert(wtxn, testObject{ID: 1, Tags: []string{"foo"}})
+	require.NoError(t, err)
+
+	_, _, err = table.CompareAndSwap(wtxn, table.Revision(wtxn), testObject{ID: 1})
+	require.ErrorIs(t, err, errNoTags)
+
+	iter, _ := table.All(wtxn)
+	require.Equal(t, []testObject{{ID: 1, Tags: []string{"foo"}}}, Collect(iter))
+}
+
+func TestDB_Modify(t *testing.T) {
+	t.Parallel()
+
+	db, table, _ := newTestDB(t, tagsIndex)
+	mergeTags := func(old, new testObject) testObject {
+		return testObject{ID: new.ID, Tags: append(slices.Clone(old.Tags), new.Tags...)}
+	}
+
+	wtxn := db.WriteTxn(table)
+	_, hadOld, err := table.Modify(wtxn, testObject{ID: 1, Tags: []string{"a"}},
+		func(old, new testObject) testObject {
+			t.Fatalf("merge called without an old object")
+			return new
+		})
+	require.NoError(t, err)
+	require.False(t, hadOld)
+
+	old, hadOld, err := table.Modify(wtxn, testObject{ID: 1, Tags: []string{"b"}}, mergeTags)
+	require.NoError(t, err)
+	require.True(t, hadOld)
+	require.Equal(t, testObject{ID: 1, Tags: []string{"a"}}, old)
+
+	obj, rev, found := table.First(wtxn, idIndex.Query(1))
+	require.True(t, found)
+	require.Equal(t, []string{"a", "b"}, obj.Tags)
+	require.Equal(t, table.Revision(wtxn), rev)
+	_, _, found = table.First(wtxn, tagsIndex.Query("b"))
+	require.True(t, found)
+
+	// Changing the primary key in the merge function is a bug.
+	require.Panics(t, func() {
+		table.Modify(wtxn, testObject{ID: 1}, func(old, new testObject) testObject {
+			return testObject{ID: 2}
+		})
+	})
+	wtxn.Abort()
+}
+
+func TestDB_Modify_SameObject(t *testing.T) {
+	t.Parallel()
+
+	idIndex := Index[*testObject, uint64]{
+		Name: "id",
+		FromObject: func(t *testObject) index.KeySet {
+			return index.NewKeySet(index.Uint64(t.ID))
+		},
+		FromKey: index.Uint64,
+		Unique:  true,
+	}
+	table, err := NewTable[*testObject]("test", idIndex)
+	require.NoError(t, err)
+	db, err := NewDB([]TableMeta{table}, NewExpVarMetrics(false))
+	require.NoError(t, err)
+
+	wtxn := db.WriteTxn(table)
+	table.Insert(wtxn, &testObject{ID: 1})
+	require.NoError(t, wtxn.Commit())
+	_, rev, _ := table.First(db.ReadTxn(), idIndex.Query(1))
+
+	// Returning the old object from the merge function leaves the table
+	// unchanged.
+	wtxn = db.WriteTxn(table)
+	old, hadOld, err := table.Modify(wtxn, &testObject{ID: 1}, func(old, new *testObject) *testObject {
+		return old
+	})
+	require.NoError(t, err)
+	require.True(t, hadOld)
+	require.Equal(t, &testObject{ID: 1}, old)
+	require.Equal(t, rev, table.Revision(wtxn))
+	require.Empty(t, Collect(table.Changes(wtxn)))
+	require.NoError(t, wtxn.Commit())
+	_, newRev, _ := table.First(db.ReadTxn(), idIndex.Query(1))
+	require.Equal(t, rev, newRev)
+}
+
+type equalObject struct {
+	ID      uint64
+	Value   string
+	Ignored int
+}
+
+func (o *equalObject) Equal(other *equalObject) bool {
+	return o.ID == other.ID && o.Value == other.Value
+}
+
+func TestDB_Equal(t *testing.T) {
+	t.Parallel()
+
+	table, err := NewTableWithOptions[testObject](
+		"test",
+		idIndex,
+		nil,
+		WithEqual(func(a, b testObject) bool {
+			return a.ID == b.ID && slices.Equal(a.Tags, b.Tags)
+		}),
+	)
+	require.NoError(t, err)
+	db, err := NewDB([]TableMeta{table}, NewExpVarMetrics(false))
+	require.NoError(t, err)
+
+	wtxn := db.WriteTxn(table)
+	table.Insert(wtxn, testObject{ID: 1, Tags: []string{"a"}})
+	wtxn.Commit()
+	_, rev, watch, _ := table.FirstWatch(db.ReadTxn(), idIndex.Query(1))
+
+	// Equal objects are not updated.
+	wtxn = db.WriteTxn(table)
+	_, hadOld, err := table.Insert(wtxn, testObject{ID: 1, Tags: []string{"a"}})
+	require.NoError(t, err)
+	require.True(t, hadOld)
+	_, _, err = table.CompareAndSwap(wtxn, rev, testObject{ID: 1, Tags: []string{"a"}})
+	require.NoError(t, err)
+	_, _, err = table.CompareAndSwap(wtxn, rev+1, testObject{ID: 1, Tags: []string{"a"}})
+	require.ErrorIs(t, err, ErrRevisionNotEqual)
+	require.Empty(t, Collect(table.Changes(wtxn)))
+	wtxn.Commit()
+
+	_, newRev, _ := table.First(db.ReadTxn(), idIndex.Query(1))
+	require.Equal(t, rev, newRev)
+	select {
+	case <-watch:
+		t.Fatalf("watch channel closed by an equal object")
+	default:
+	}
+
+	// Modify keeps the revision of an equal merged object. The check is done
+	// after the insert and thus the watch channel may be closed.
+	wtxn = db.WriteTxn(table)
+	_, _, err = table.Modify(wtxn, testObject{ID: 1}, func(old, new testObject) testObject {
+		return old
+	})
+	require.NoError(t, err)
+	require.Empty(t, Collect(table.Changes(wtxn)))
+	wtxn.Commit()
+	_, newRev, _ = table.First(db.ReadTxn(), idIndex.Query(1))
+	require.Equal(t, rev, newRev)
+
+	// Changed objects are updated.
+	wtxn = db.WriteTxn(table)
+	table.Insert(wtxn, testObject{ID: 1, Tags: []string{"b"}})
+	wtxn.Commit()
+	<-watch
+	_, newRev, _ = table.First(db.ReadTxn(), idIndex.Query(1))
+	require.Greater(t, newRev, rev)
+
+	// The Equal method of the object can be used.
+	equalTable, err := NewTableWithOptions[*equalObject](
+		"equal",
+		Index[*equalObject, uint64]{
+			Name: "id",
+			FromObject: func(o *equalObject) index.KeySet {
+				return index.NewKeySet(index.Uint64(o.ID))
+			},
+			FromKey: index.Uint64,
+			Unique:  true,
+		},
+		nil,
+		WithEqualMethod[*equalObject](),
+	)
+	require.NoError(t, err)
+	require.NoError(t, db.RegisterTable(equalTable))
+	wtxn = db.WriteTxn(equalTable)
+	equalTable.Insert(wtxn, &equalObject{ID: 1, Value: "a"})
+	wtxn.Commit()
+	wtxn = db.WriteTxn(equalTable)
+	equalTable.Insert(wtxn, &equalObject{ID: 1, Value: "a", Ignored: 1})
+	wtxn.Commit()
+	obj, _, _ := equalTable.First(db.ReadTxn(), Query[*equalObject]{index: "id", key: index.Uint64(1)})
+	require.Zero(t, obj.Ignored)
+
+	// Mutating the object is still caught.
+	wtxn = db.WriteTxn(equalTable)
+	defer wtxn.Abort()
+	require.Panics(t, func() {
+		equalTable.Insert(wtxn, obj)
+	})
+}
+
+func TestDB_InsertMany(t *testing.T) {
+	t.Parallel()
+
+	db, table, _ := newTestDB(t, tagsIndex)
+
+	// Deleted objects are removed from the graveyard when bulk loading.
+	wtxn := db.WriteTxn(table)
+	dt, err := table.DeleteTracker(wtxn, "test")
+	require.NoError(t, err)
+	defer dt.Close()
+	table.Insert(wtxn, testObject{ID: 2})
+	table.Delete(wtxn, testObject{ID: 2})
+	wtxn.Commit()
+
+	wtxn = db.WriteTxn(table)
+	err = table.InsertMany(wtxn, FromSlice([]testObject{
+		{ID: 3, Tags: []string{"a"}},
+		{ID: 1, Tags: []string{"a", "b"}},
+		{ID: 2, Tags: []string{"b"}},
+		{ID: 1, Tags: []string{"c"}},
+	}))
+	require.NoError(t, err)
+	require.Empty(t, Collect(dt.Deleted(wtxn, 0)))
+	wtxn.Commit()
+
+	// The last one of the objects with the same primary key is kept
+	// and the revisions follow the given order.
+	txn := db.ReadTxn()
+	require.Equal(t, 3, table.NumObjects(txn))
+	iter, _ := table.All(txn)
+	objs, revs := []testObject{}, []Revision{}
+	for obj, rev, ok := iter.Next(); ok; obj, rev, ok = iter.Next() {
+		objs = append(objs, obj)
+		revs = append(revs, rev)
+	}
+	require.Equal(t, []testObject{
+		{ID: 1, Tags: []string{"c"}},
+		{ID: 2, Tags: []string{"b"}},
+		{ID: 3, Tags: []string{"a"}},
+	}, objs)
+	require.Equal(t, []Revision{6, 5, 3}, revs)
+	require.Equal(t, Revision(6), table.Revision(txn))
+
+	iter, _ = table.Get(txn, tagsIndex.Query("a"))
+	require.Equal(t, []testObject{{ID: 3, Tags: []string{"a"}}}, Collect(iter))
+	iter, _ = table.Get(txn, tagsIndex.Query("b"))
+	require.Equal(t, []testObject{{ID: 2, Tags: []string{"b"}}}, Collect(iter))
+
+	// Into a non-empty table the objects are inserted one at a time.
+	wtxn = db.WriteTxn(table)
+	err = table.InsertMany(wtxn, FromSlice([]testObject{
+		{ID: 1, Tags: []string{"d"}},
+		{ID: 4},
+	}))
+	require.NoError(t, err)
+	require.Len(t, Collect(table.Changes(wtxn)), 2)
+	wtxn.Commit()
+	iter, _ = table.Get(db.ReadTxn(), tagsIndex.Query("c"))
+	require.Empty(t, Collect(iter))
+	iter, _ = table.Get(db.ReadTxn(), tagsIndex.Query("d"))
+	require.Len(t, Collect(iter), 1)
+}
+
+func TestDB_InsertMany_Unique(t *testing.T) {
+	t.Parallel()
+
+	uniqueTagsIndex := Index[testObject, string]{
+		Name: "unique-tags",
+		FromObject: func(t testObject) index.KeySet {
+			return index.StringSlice(t.Tags)
+		},
+		FromKey: index.String,
+		Unique:  true,
+	}
+	db, table, _ := newTestDB(t, uniqueTagsIndex)
+
+	wtxn := db.WriteTxn(table)
+	defer wtxn.Abort()
+	err := table.InsertMany(wtxn, FromSlice([]testObject{
+		{ID: 1, Tags: []string{"a"}},
+		{ID: 2, Tags: []string{"a"}},
+	}))
+	require.ErrorIs(t, err, ErrUniqueConstraintViolation)
+	require.Empty(t, Collect(table.Changes(wtxn)))
+
+	// Replacing an object with the same key is fine.
+	err = table.InsertMany(wtxn, FromSlice([]testObject{
+		{ID: 1, Tags: []string{"a"}},
+		{ID: 1, Tags: []string{"a"}},
+	}))
+	require.NoError(t, err)
+}
+
+func TestDB_Capacity(t *testing.T) {
+	t.Parallel()
+
+	metrics := NewExpVarMetrics(false)
+	newTable := func(name string, policy EvictionPolicy) RWTable[testObject] {
+		table, err := NewTableWithOptions[testObject](
+			name,
+			idIndex,
+			[]Indexer[testObject]{tagsIndex},
+			WithCapacity[testObject](2, policy),
+		)
+		require.NoError(t, err)
+		return table
+	}
+	rejecting := newTable("rejecting", RejectWhenFull)
+	oldest := newTable("oldest", EvictOldest)
+	byTags := newTable("by-tags", EvictByIndex(tagsIndex.Name))
+	db, err := NewDB([]TableMeta{rejecting, oldest, byTags}, metrics)
+	require.NoError(t, err)
+
+	wtxn := db.WriteTxn(rejecting, oldest, byTags)
+	for _, table := range []RWTable[testObject]{rejecting, oldest, byTags} {
+		_, _, err = table.Insert(wtxn, testObject{ID: 1, Tags: []string{"b"}})
+		require.NoError(t, err)
+		_, _, err = table.Insert(wtxn, testObject{ID: 2, Tags: []string{"a"}})
+		require.NoError(t, err)
+
+		// Replacing an object in a full table does not need room.
+		_, _, err = table.Insert(wtxn, testObject{ID: 1, Tags: []string{"b"}})
+		require.NoError(t, err)
+	}
+
+	_, _, err = rejecting.Insert(wtxn, testObject{ID: 3})
+	require.ErrorIs(t, err, ErrTableFull)
+	iter, _ := rejecting.All(wtxn)
+	require.Equal(t, []uint64{1, 2}, objectIDs(Collect(iter)))
+
+	// Object 2 has the lowest revision after object 1 was replaced.
+	_, _, err = oldest.Insert(wtxn, testObject{ID: 3})
+	require.NoError(t, err)
+	iter, _ = oldest.All(wtxn)
+	require.Equal(t, []uint64{1, 3}, objectIDs(Collect(iter)))
+
+	// Object 2 is first in the order of the tags index.
+	_, _, err = byTags.Insert(wtxn, testObject{ID: 3})
+	require.NoError(t, err)
+	iter, _ = byTags.All(wtxn)
+	require.Equal(t, []uint64{1, 3}, objectIDs(Collect(iter)))
+
+	// Objects not in the eviction index are not evicted.
+	_, _, err = byTags.Insert(wtxn, testObject{ID: 4})
+	require.NoError(t, err)
+	iter, _ = byTags.All(wtxn)
+	require.Equal(t, []uint64{3, 4}, objectIDs(Collect(iter)))
+	_, _, err = byTags.Insert(wtxn, testObject{ID: 5})
+	require.ErrorIs(t, err, ErrTableFull)
+
+	// Rolling back restores the object count.
+	sp := wtxn.Savepoint()
+	byTags.Delete(wtxn, testObject{ID: 3})
+	require.NoError(t, wtxn.RollbackTo(sp))
+	_, _, err = byTags.Insert(wtxn, testObject{ID: 5})
+	require.ErrorIs(t, err, ErrTableFull)
+	require.NoError(t, wtxn.Commit())
+
+	// Deleting makes room.
+	wtxn = db.WriteTxn(rejecting)
+	rejecting.Delete(wtxn, testObject{ID: 1})
+	_, _, err = rejecting.Insert(wtxn, testObject{ID: 3})
+	require.NoError(t, err)
+	require.NoError(t, wtxn.Commit())
+	iter, _ = rejecting.All(db.ReadTxn())
+	require.Equal(t, []uint64{2, 3}, objectIDs(Collect(iter)))
+
+	require.EqualValues(t, 1, expvarInt(metrics.CapacityRejectionsVar.Get("rejecting")))
+	require.EqualValues(t, 1, expvarInt(metrics.CapacityEvictionsVar.Get("oldest")))
+	require.EqualValues(t, 2, expvarInt(metrics.CapacityEvictionsVar.Get("by-tags")))
+	require.EqualValues(t, 2, expvarInt(metrics.CapacityRejectionsVar.Get("by-tags")))
+}
+
+func TestDB_OptionalMetrics(t *testing.T) {
+	t.Parallel()
+
+	// An implementation of only the Metrics interface works with the features
+	// reporting the optional metrics.
+	var metrics struct{ Metrics }
+	metrics.Metrics = &NopMetrics{}
+	table, err := NewTableWithOptions[testObject]("test", idIndex, nil,
+		WithCapacity[testObject](1, RejectWhenFull))
+	require.NoError(t, err)
+	db, err := NewDB([]TableMeta{table}, metrics)
+	require.NoError(t, err)
+	db.SetHistoryRetention(1, 0)
+
+	wtxn := db.WriteTxn(table)
+	_, _, err = table.Insert(wtxn, testObject{ID: 1})
+	require.NoError(t, err)
+	_, _, err = table.Insert(wtxn, testObject{ID: 2})
+	require.ErrorIs(t, err, ErrTableFull)
+	require.NoError(t, wtxn.Commit())
+	require.Len(t, db.History(), 1)
+}
+
 func TestDB_CompareAndSwap_CompareAndDelete(t *testing.T) {
 	t.Parallel()
 
@@ -0,0 +1,163 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package statedb
+
+import (
+	"bytes"
+
+	iradix "github.com/hashicorp/go-immutable-radix/v2"
+)
+
+// Diff returns the changes to the objects in the table between two snapshots
+// of the database, e.g. two read transactions taken at different times, or
+// read transactions of a database and of its fork. The changes are in the
+// order of the primary keys:
+//
+//   - An added object has HadOld false and New set.
+//   - A removed object has HadOld and Deleted true and Old set.
+//   - A changed object has HadOld true and both Old and New set.
+//
+// The revision of a change is that of the new object, or for a removed
+// object the revision of the old object.
+//
+// The primary indexes of the snapshots are walked in lockstep and the parts
+// of the radix trees that are shared by the snapshots are skipped. Thus the
+// cost is proportional to the amount of changes rather than to the size of
+// the table.
+func Diff[Obj any](table Table[Obj], oldTxn, newTxn ReadTxn) Iterator[Change[Obj]] {
+	return diff(table, oldTxn, newTxn, false)
+}
+
+// diff implements Diff. If the snapshots are of the same table the objects
+// are compared by revision, which skips an object that was reinserted as is.
+func diff[Obj any](table Table[Obj], oldTxn, newTxn ReadTxn, sameTable bool) Iterator[Change[Obj]] {
+	oldRoot := oldTxn.getTxn().mustIndexReadTxn(table, PrimaryIndexPos).Root()
+	newRoot := newTxn.getTxn().mustIndexReadTxn(table, PrimaryIndexPos).Root()
+	if oldRoot == newRoot {
+		return &sliceIterator[Change[Obj]]{}
+	}
+	iter := &diffIterator[Obj]{
+		old:       diffSide{root: oldRoot, iter: oldRoot.Iterator()},
+		new:       diffSide{root: newRoot, iter: newRoot.Iterator()},
+		sameTable: sameTable,
+	}
+	iter.old.next()
+	iter.new.next()
+	return iter
+}
+
+// diffSide is the iteration state of one of the snapshots.
+type diffSide struct {
+	root *iradix.Node[object]
+	iter *iradix.Iterator[object]
+	key  []byte
+	obj  object
+	ok   bool
+}
+
+func (s *diffSide) next() {
+	s.key, s.obj, s.ok = s.iter.Next()
+}
+
+// seek moves to the first key that is equal to or larger than the given key.
+func (s *diffSide) seek(key []byte) {
+	s.iter = s.root.Iterator()
+	s.iter.SeekLowerBound(key)
+	s.next()
+}
+
+// watch returns the watch channel of the node at the prefix.
+func (s *diffSide) watch(prefix []byte) <-chan struct{} {
+	return s.root.Iterator().SeekPrefixWatch(prefix)
+}
+
+type diffIterator[Obj any] struct {
+	old, new diffSide
+
+	// differing is the longest prefix known to be at different nodes in
+	// the snapshots. The nodes at its prefixes then differ as well.
+	differing []byte
+
+	// sameTable is true if the revisions of the snapshots are comparable.
+	sameTable bool
+}
+
+func (it *diffIterator[Obj]) Next() (change Change[Obj], revision Revision, ok bool) {
+	for it.old.ok || it.new.ok {
+		key := it.old.key
+		if !it.old.ok || (it.new.ok && bytes.Compare(it.new.key, key) < 0) {
+			key = it.new.key
+		}
+
+		// Skip over the subtree shared by both snapshots that contains the
+		// key. Each node has its own watch channel and thus the nodes are
+		// the same if their watch channels are.
+		if prefix, shared := it.sharedPrefix(key); shared {
+			end := prefixEnd(prefix)
+			if end == nil {
+				return
+			}
+			it.old.seek(end)
+			it.new.seek(end)
+			continue
+		}
+
+		switch {
+		case it.old.ok && it.new.ok && bytes.Equal(it.old.key, it.new.key):
+			// The object exists in both snapshots. Compare the leaves as
+			// revisions of different databases may be the same.
+			oldWatch, _, _ := it.old.root.GetWatch(key)
+			newWatch, _, _ := it.new.root.GetWatch(key)
+			oldObj, newObj := it.old.obj, it.new.obj
+			it.old.next()
+			it.new.next()
+			if oldWatch == newWatch || (it.sameTable && oldObj.revision == newObj.revision) {
+				continue
+			}
+			change.Old, change.HadOld = oldObj.data.(Obj), true
+			change.New = newObj.data.(Obj)
+			return change, newObj.revision, true
+		case it.old.ok && bytes.Equal(it.old.key, key):
+			oldObj := it.old.obj
+			it.old.next()
+			change.Old, change.HadOld, change.Deleted = oldObj.data.(Obj), true, true
+			return change, oldObj.revision, true
+		default:
+			newObj := it.new.obj
+			it.new.next()
+			change.New = newObj.data.(Obj)
+			return change, newObj.revision, true
+		}
+	}
+	return
+}
+
+// sharedPrefix returns the shortest prefix of the key at which the snapshots
+// share the node. The prefixes known to differ are not checked.
+func (it *diffIterator[Obj]) sharedPrefix(key []byte) ([]byte, bool) {
+	i := 0
+	for i < len(key) && i < len(it.differing) && key[i] == it.differing[i] {
+		i++
+	}
+	for i++; i <= len(key); i++ {
+		if it.old.watch(key[:i]) == it.new.watch(key[:i]) {
+			return key[:i], true
+		}
+		it.differing = key[:i]
+	}
+	return nil, false
+}
+
+// prefixEnd returns the smallest key that is larger than all the keys with
+// the given prefix, or nil if there is no such key.
+func prefixEnd(prefix []byte) []byte {
+	end := bytes.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package statedb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	t.Parallel()
+
+	db, table, _ := newTestDB(t, tagsIndex)
+
+	wtxn := db.WriteTxn(table)
+	for id := uint64(0); id < 10000; id++ {
+		table.Insert(wtxn, testObject{ID: id})
+	}
+	wtxn.Commit()
+	oldTxn := db.ReadTxn()
+	require.Empty(t, Collect(Diff(table, oldTxn, oldTxn)))
+
+	wtxn = db.WriteTxn(table)
+	table.Insert(wtxn, testObject{ID: 5, Tags: []string{"changed"}})
+	table.Insert(wtxn, testObject{ID: 9000, Tags: []string{"changed"}})
+	table.Insert(wtxn, testObject{ID: 20000})
+	table.Delete(wtxn, testObject{ID: 0})
+	table.Delete(wtxn, testObject{ID: 256})
+	wtxn.Commit()
+	newTxn := db.ReadTxn()
+
+	changes := Collect(Diff(table, oldTxn, newTxn))
+	require.Equal(t, []Change[testObject]{
+		{Old: testObject{ID: 0}, HadOld: true, Deleted: true},
+		{Old: testObject{ID: 5}, HadOld: true, New: testObject{ID: 5, Tags: []string{"changed"}}},
+		{Old: testObject{ID: 256}, HadOld: true, Deleted: true},
+		{Old: testObject{ID: 9000}, HadOld: true, New: testObject{ID: 9000, Tags: []string{"changed"}}},
+		{New: testObject{ID: 20000}},
+	}, changes)
+
+	// Reversing the snapshots reverses the changes.
+	changes = Collect(Diff(table, newTxn, oldTxn))
+	require.Len(t, changes, 5)
+	require.Equal(t, Change[testObject]{New: testObject{ID: 0}}, changes[0])
+	require.Equal(t, Change[testObject]{Old: testObject{ID: 20000}, HadOld: true, Deleted: true}, changes[4])
+
+	// The revisions are those of the new objects.
+	iter := Diff(table, oldTxn, newTxn)
+	_, rev, _ := iter.Next()
+	_, oldRev, _ := table.First(oldTxn, idIndex.Query(0))
+	require.Equal(t, oldRev, rev)
+	_, rev, _ = iter.Next()
+	_, newRev, _ := table.First(newTxn, idIndex.Query(5))
+	require.Equal(t, newRev, rev)
+
+	// Diffing against a fork finds the changes even if the revisions match.
+	fork := db.Fork()
+	wtxn = db.WriteTxn(table)
+	table.Insert(wtxn, testObject{ID: 1, Tags: []string{"original"}})
+	wtxn.Commit()
+	wtxn = fork.WriteTxn(table)
+	table.Insert(wtxn, testObject{ID: 1, Tags: []string{"fork"}})
+	wtxn.Commit()
+	iter = Diff(table, db.ReadTxn(), fork.ReadTxn())
+	change, rev, ok := iter.Next()
+	require.True(t, ok)
+	require.Equal(t, table.Revision(db.ReadTxn()), rev)
+	require.Equal(t, []string{"original"}, change.Old.Tags)
+	require.Equal(t, []string{"fork"}, change.New.Tags)
+	_, _, ok = iter.Next()
+	require.False(t, ok)
+}
+
+func TestDiff_PrefixEnd(t *testing.T) {
+	require.Equal(t, []byte{1}, prefixEnd([]byte{0}))
+	require.Equal(t, []byte{1, 3}, prefixEnd([]byte{1, 2}))
+	require.Equal(t, []byte{2}, prefixEnd([]byte{1, 0xff}))
+	require.Nil(t, prefixEnd([]byte{0xff, 0xff}))
+	require.Nil(t, prefixEnd(nil))
+}
+
+func BenchmarkDiff(b *testing.B) {
+	db, table := newTestDBWithMetrics(b, &NopMetrics{})
+	wtxn := db.WriteTxn(table)
+	for id := uint64(0); id < 100000; id++ {
+		table.Insert(wtxn, testObject{ID: id})
+	}
+	wtxn.Commit()
+	oldTxn := db.ReadTxn()
+	wtxn = db.WriteTxn(table)
+	table.Insert(wtxn, testObject{ID: 50000, Tags: []string{"changed"}})
+	wtxn.Commit()
+	newTxn := db.ReadTxn()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		require.Len(b, Collect(Diff(table, oldTxn, newTxn)), 1)
+	}
+}
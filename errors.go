@@ -6,6 +6,8 @@ package statedb
 import (
 	"errors"
 	"fmt"
+
+	"github.com/cilium/statedb/index"
 )
 
 var (
@@ -32,6 +34,26 @@ var (
 	// WriteTxn().
 	ErrTableNotLockedForWriting = errors.New("not locked for writing")
 
+	// ErrIndexNotFound indicates that a query or DropIndex refers to an index that
+	// the table does not have.
+	ErrIndexNotFound = errors.New("index not found")
+
+	// ErrUniqueConstraintViolation indicates that an object could not be inserted as
+	// another object already has the same key in a unique secondary index.
+	ErrUniqueConstraintViolation = errors.New("unique constraint violation")
+
+	// ErrInvalidObject indicates that an object was rejected by a validator of the table.
+	// See WithValidator.
+	ErrInvalidObject = errors.New("invalid object")
+
+	// ErrTableFull indicates that an object could not be inserted as the table is at its
+	// capacity and no object could be evicted. See WithCapacity.
+	ErrTableFull = errors.New("table full")
+
+	// ErrForeignKeyViolation indicates that a write transaction could not be committed
+	// as its changes would leave objects referencing missing objects. See AddForeignKey.
+	ErrForeignKeyViolation = errors.New("foreign key violation")
+
 	// ErrRevisionNotEqual indicates that the CompareAndSwap or CompareAndDelete failed due to
 	// the object having a mismatching revision, e.g. it had been changed since the object
 	// was last read.
@@ -41,9 +63,48 @@ var (
 	// it to exists. This error is not returned by Insert or Delete, but may be returned by
 	// CompareAndSwap or CompareAndDelete.
 	ErrObjectNotFound = errors.New("object not found")
+
+	// ErrInvalidSavepoint indicates that RollbackTo was called with a savepoint that was
+	// not created by the write transaction or which was invalidated by rolling back to an
+	// earlier savepoint.
+	ErrInvalidSavepoint = errors.New("invalid savepoint")
+
+	// ErrTableNotRegistered indicates that a table referred to by name, e.g. in a snapshot
+	// given to Restore, has not been registered to the database.
+	ErrTableNotRegistered = errors.New("table not registered")
+
+	// ErrTableHasDeleteTrackers indicates that UnregisterTable was called on a table that
+	// has delete trackers that have not been closed.
+	ErrTableHasDeleteTrackers = errors.New("table has open delete trackers")
+
+	// ErrTableReferenced indicates that UnregisterTable was called on a table that is
+	// referenced by a foreign key of another table. See AddForeignKey.
+	ErrTableReferenced = errors.New("table referenced by a foreign key")
+
+	// ErrIndexReferenced indicates that DropIndex was called on an index that is used
+	// by a foreign key. See AddForeignKey.
+	ErrIndexReferenced = errors.New("index used by a foreign key")
+
+	// ErrCommitNotRetained indicates that ReadTxnAt was called with a commit ID that is
+	// not retained in the history. See DB.SetHistoryRetention.
+	ErrCommitNotRetained = errors.New("commit not retained")
+
+	// ErrInvalidSnapshot indicates that the data given to Restore is not a snapshot written
+	// by Snapshot.
+	ErrInvalidSnapshot = errors.New("invalid snapshot")
+
+	// ErrCorruptJournal indicates that a journal segment contains a record that is
+	// not a valid record written by the journal.
+	ErrCorruptJournal = errors.New("corrupt journal")
 )
 
 // tableError wraps an error with the table name.
 func tableError(tableName string, err error) error {
 	return fmt.Errorf("table %q: %w", tableName, err)
 }
+
+// uniqueConstraintError returns ErrUniqueConstraintViolation wrapped with the
+// table, index and key.
+func uniqueConstraintError(tableName string, indexName string, key index.Key) error {
+	return tableError(tableName, fmt.Errorf("index %q: key %q: %w", indexName, []byte(key), ErrUniqueConstraintViolation))
+}
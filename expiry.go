@@ -0,0 +1,160 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package statedb
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/cilium/statedb/index"
+)
+
+const (
+	// defaultExpiryInterval is the default interval at which expired objects
+	// are looked for.
+	defaultExpiryInterval = time.Second
+
+	// expiryBatchSize is the maximum number of expired objects deleted in
+	// a single write transaction.
+	expiryBatchSize = 1000
+
+	// maxExpiryBackoff is the maximum time to wait before retrying to delete
+	// the expired objects from a table after a failure.
+	maxExpiryBackoff = time.Minute
+)
+
+// expiryBackoff is the state of a table from which the expired objects failed
+// to be deleted, e.g. due to a foreign key violation.
+type expiryBackoff struct {
+	failures int
+	retryAt  time.Time
+}
+
+// expiryKey encodes the expiry time as the key in the expiry index. The times
+// prior to the Unix epoch are clamped to it.
+func expiryKey(t time.Time) index.Key {
+	return index.Uint64(uint64(max(t.UnixNano(), 0)))
+}
+
+func expiryWorker(db *DB, ctx context.Context, interval time.Duration) {
+	defer close(db.expiryExited)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			db.deleteExpired(ctx, now)
+		}
+	}
+}
+
+// deleteExpired deletes the objects that have expired by the given time. The
+// tables for which the deletion failed are skipped until their back-off has
+// passed. Returns early if the context is cancelled.
+func (db *DB) deleteExpired(ctx context.Context, now time.Time) {
+	for _, meta := range db.state.Load().root.tables() {
+		if backoff, ok := db.expiryBackoffs[meta]; ok && now.Before(backoff.retryAt) {
+			continue
+		}
+		for {
+			deleted, err := db.deleteExpiredBatch(ctx, meta, now)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				db.expiryFailed(meta, now, err)
+				break
+			}
+			delete(db.expiryBackoffs, meta)
+			if deleted < expiryBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// expiryFailed logs the failure to delete the expired objects from the table
+// and doubles the time to wait before retrying.
+func (db *DB) expiryFailed(meta TableMeta, now time.Time, err error) {
+	if db.expiryBackoffs == nil {
+		db.expiryBackoffs = map[TableMeta]expiryBackoff{}
+	}
+	backoff := db.expiryBackoffs[meta]
+	backoff.failures++
+	wait := db.expiryInterval
+	for i := 1; i < backoff.failures && wait < maxExpiryBackoff; i++ {
+		wait *= 2
+	}
+	wait = min(wait, maxExpiryBackoff)
+	backoff.retryAt = now.Add(wait)
+	db.expiryBackoffs[meta] = backoff
+
+	slog.Default().Warn("Failed to delete expired objects",
+		"table", meta.Name(),
+		"error", err,
+		"failures", backoff.failures,
+		"retryIn", wait)
+}
+
+// deleteExpiredBatch deletes up to expiryBatchSize expired objects from the
+// table in a single write transaction. Returns the number of deleted objects.
+func (db *DB) deleteExpiredBatch(ctx context.Context, meta TableMeta, now time.Time) (int, error) {
+	// Do a lockless read transaction to find the expired objects to not
+	// lock the table when nothing has expired.
+	rtxn := db.ReadTxn().getTxn()
+	if !rtxn.root.isRegistered(meta) {
+		return 0, nil
+	}
+	expiry, ok := rtxn.root[meta.tablePos()].secondary[ExpiryIndex]
+	if !ok {
+		return 0, nil
+	}
+	expired := findExpired(rtxn.mustIndexReadTxn(meta, expiry.pos), now)
+	if len(expired) == 0 {
+		return 0, nil
+	}
+
+	wtxn, err := db.NewHandle("expiry").WriteTxnContext(ctx, meta)
+	if err != nil {
+		if errors.Is(err, ErrTableNotRegistered) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	txn := wtxn.getTxn()
+	defer txn.Abort()
+	deleted := 0
+	for _, obj := range expired {
+		// The object is not deleted if it has been updated since.
+		if _, hadOld, err := txn.Delete(meta, obj.revision, obj.data); hadOld && err == nil {
+			deleted++
+		}
+	}
+	if err := txn.Commit(); err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+// findExpired returns up to expiryBatchSize objects from the expiry index that
+// have expired by the given time.
+func findExpired(expiryIndex indexReadTxn, now time.Time) []object {
+	nowKey := expiryKey(now)
+	var expired []object
+	iter := expiryIndex.Root().Iterator()
+	for key, obj, ok := iter.Next(); ok && len(expired) < expiryBatchSize; key, obj, ok = iter.Next() {
+		_, secondary := decodeNonUniqueKey(key)
+		if binary.BigEndian.Uint64(secondary) > binary.BigEndian.Uint64(nowKey) {
+			break
+		}
+		expired = append(expired, obj)
+	}
+	return expired
+}
@@ -0,0 +1,184 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package statedb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cilium/statedb/index"
+)
+
+type expiringObject struct {
+	ID        uint64
+	ExpiresAt time.Time
+}
+
+func (o expiringObject) getID() uint64 {
+	return o.ID
+}
+
+var expiringIDIndex = Index[expiringObject, uint64]{
+	Name: "id",
+	FromObject: func(obj expiringObject) index.KeySet {
+		return index.NewKeySet(index.Uint64(obj.ID))
+	},
+	FromKey: index.Uint64,
+	Unique:  true,
+}
+
+func newExpiringTable(t testing.TB) (*DB, RWTable[expiringObject]) {
+	table, err := NewTableWithOptions[expiringObject](
+		"expiring",
+		expiringIDIndex,
+		nil,
+		WithExpiry(func(obj expiringObject) time.Time { return obj.ExpiresAt }),
+	)
+	require.NoError(t, err)
+	db, err := NewDB([]TableMeta{table}, NewExpVarMetrics(false))
+	require.NoError(t, err)
+	return db, table
+}
+
+func TestDB_Expiry(t *testing.T) {
+	t.Parallel()
+
+	db, table := newExpiringTable(t)
+	now := time.Now()
+
+	wtxn := db.WriteTxn(table)
+	dt, err := table.DeleteTracker(wtxn, "test")
+	require.NoError(t, err)
+	defer dt.Close()
+	table.Insert(wtxn, expiringObject{ID: 1, ExpiresAt: now.Add(-time.Second)})
+	table.Insert(wtxn, expiringObject{ID: 2, ExpiresAt: now.Add(time.Second)})
+	table.Insert(wtxn, expiringObject{ID: 3})
+	table.Insert(wtxn, expiringObject{ID: 4, ExpiresAt: now})
+	wtxn.Commit()
+
+	// Only the objects that have expired by now are deleted.
+	db.deleteExpired(context.TODO(), now)
+	iter, _ := table.All(db.ReadTxn())
+	require.Equal(t, []uint64{2, 3}, Collect(Map(iter, expiringObject.getID)))
+
+	// The deletions are seen by the delete trackers.
+	var deleted []uint64
+	dt.Iterate(db.ReadTxn(), func(obj expiringObject, isDeleted bool, rev Revision) {
+		if isDeleted {
+			deleted = append(deleted, obj.ID)
+		}
+	})
+	require.Equal(t, []uint64{1, 4}, deleted)
+
+	// An object that is updated to not expire is not deleted.
+	wtxn = db.WriteTxn(table)
+	table.Insert(wtxn, expiringObject{ID: 2})
+	wtxn.Commit()
+	db.deleteExpired(context.TODO(), now.Add(time.Hour))
+	iter, _ = table.All(db.ReadTxn())
+	require.Equal(t, []uint64{2, 3}, Collect(Map(iter, expiringObject.getID)))
+
+	// Expiry of more objects than fit into a batch.
+	wtxn = db.WriteTxn(table)
+	for i := 0; i < 2*expiryBatchSize+1; i++ {
+		table.Insert(wtxn, expiringObject{ID: uint64(100 + i), ExpiresAt: now})
+	}
+	wtxn.Commit()
+	require.Equal(t, 2*expiryBatchSize+3, table.NumObjects(db.ReadTxn()))
+	db.deleteExpired(context.TODO(), now)
+	require.Equal(t, 2, table.NumObjects(db.ReadTxn()))
+}
+
+func TestDB_ExpiryWorker(t *testing.T) {
+	t.Parallel()
+
+	db, table := newExpiringTable(t)
+	db.setExpiryInterval(10 * time.Millisecond)
+	require.NoError(t, db.Start(context.TODO()))
+	t.Cleanup(func() {
+		require.NoError(t, db.Stop(context.TODO()))
+	})
+
+	wtxn := db.WriteTxn(table)
+	table.Insert(wtxn, expiringObject{ID: 1, ExpiresAt: time.Now().Add(20 * time.Millisecond)})
+	table.Insert(wtxn, expiringObject{ID: 2})
+	wtxn.Commit()
+
+	require.Eventually(t,
+		func() bool {
+			_, _, found := table.First(db.ReadTxn(), expiringIDIndex.Query(1))
+			return !found
+		},
+		5*time.Second, 10*time.Millisecond)
+	_, _, found := table.First(db.ReadTxn(), expiringIDIndex.Query(2))
+	require.True(t, found)
+}
+
+func TestDB_ExpiryFailure(t *testing.T) {
+	t.Parallel()
+
+	db, table := newExpiringTable(t)
+	children := MustNewTable[refObject]("children", refIDIndex, refParentIndex)
+	require.NoError(t, db.RegisterTable(children))
+	require.NoError(t, AddForeignKey(db, children, refParentIndex, table, OnDeleteReject))
+	now := time.Now()
+
+	wtxn := db.WriteTxn(table, children)
+	table.Insert(wtxn, expiringObject{ID: 1, ExpiresAt: now})
+	children.Insert(wtxn, refObject{ID: 1, Parent: 1})
+	require.NoError(t, wtxn.Commit())
+
+	// The expired object is referenced and cannot be deleted. The table is
+	// not retried until the back-off has passed.
+	db.deleteExpired(context.TODO(), now)
+	require.Equal(t, 1, table.NumObjects(db.ReadTxn()))
+	backoff := db.expiryBackoffs[table]
+	require.Equal(t, 1, backoff.failures)
+	require.Equal(t, now.Add(db.expiryInterval), backoff.retryAt)
+	db.deleteExpired(context.TODO(), now)
+	require.Equal(t, backoff, db.expiryBackoffs[table])
+
+	// The back-off doubles on each failure up to the maximum.
+	db.deleteExpired(context.TODO(), backoff.retryAt)
+	require.Equal(t, backoff.retryAt.Add(2*db.expiryInterval), db.expiryBackoffs[table].retryAt)
+	for i := 0; i < 20; i++ {
+		db.deleteExpired(context.TODO(), db.expiryBackoffs[table].retryAt)
+	}
+	backoff = db.expiryBackoffs[table]
+	require.Equal(t, 22, backoff.failures)
+
+	// Once the reference is gone the object is deleted on the next retry.
+	wtxn = db.WriteTxn(children)
+	children.Delete(wtxn, refObject{ID: 1})
+	require.NoError(t, wtxn.Commit())
+	db.deleteExpired(context.TODO(), backoff.retryAt.Add(-time.Second))
+	require.Equal(t, 1, table.NumObjects(db.ReadTxn()))
+	db.deleteExpired(context.TODO(), backoff.retryAt)
+	require.Zero(t, table.NumObjects(db.ReadTxn()))
+	require.Empty(t, db.expiryBackoffs)
+}
+
+func TestDB_ExpiryCancelled(t *testing.T) {
+	t.Parallel()
+
+	db, table := newExpiringTable(t)
+	now := time.Now()
+	wtxn := db.WriteTxn(table)
+	table.Insert(wtxn, expiringObject{ID: 1, ExpiresAt: now})
+	require.NoError(t, wtxn.Commit())
+
+	// A writer holding the table does not block the worker once its context
+	// is cancelled, and the cancellation is not a failure to back off from.
+	wtxn = db.WriteTxn(table)
+	defer wtxn.Abort()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := db.deleteExpiredBatch(ctx, table, now)
+	require.ErrorIs(t, err, context.Canceled)
+	db.deleteExpired(ctx, now)
+	require.Empty(t, db.expiryBackoffs)
+}
@@ -0,0 +1,229 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package statedb
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+
+	"github.com/cilium/statedb/index"
+)
+
+// OnDeletePolicy specifies what happens when an object referenced by a foreign
+// key is deleted. See AddForeignKey.
+type OnDeletePolicy int
+
+const (
+	// OnDeleteReject fails the commit of a write transaction that deletes an
+	// object that is still referenced.
+	OnDeleteReject OnDeletePolicy = iota
+
+	// OnDeleteCascade deletes the referencing objects together with the
+	// referenced object. The write transaction must lock the referencing
+	// table.
+	OnDeleteCascade
+)
+
+func (p OnDeletePolicy) String() string {
+	switch p {
+	case OnDeleteReject:
+		return "reject"
+	case OnDeleteCascade:
+		return "cascade"
+	}
+	return fmt.Sprintf("OnDeletePolicy(%d)", int(p))
+}
+
+// foreignKey is the untyped form of a foreign key relation between two tables.
+type foreignKey struct {
+	child      TableMeta
+	childIndex anyIndexer
+	parent     TableMeta
+	onDelete   OnDeletePolicy
+}
+
+// AddForeignKey declares that the objects in the 'child' table reference the
+// objects in the 'parent' table. The keys returned by 'childIndex' for a child
+// object are the primary keys of the parent objects it references. The index
+// must be a secondary index of the child table and is used to find the child
+// objects referencing a parent object.
+//
+// The relation is checked on Commit() of the write transactions that modify
+// either table. Commit fails with ErrForeignKeyViolation if an inserted child
+// object references a parent object that does not exist, or, with the
+// OnDeleteReject policy, if a deleted parent object is still referenced.
+// With the OnDeleteCascade policy the referencing child objects are deleted
+// instead. The tables not locked by the write transaction are checked against
+// their latest committed state.
+//
+// The objects already in the tables are not checked and neither are the
+// write transactions created before the foreign key was added.
+func AddForeignKey[Child, Parent any](db *DB, child RWTable[Child], childIndex Indexer[Child], parent Table[Parent], onDelete OnDeletePolicy) error {
+	return db.addForeignKey(&foreignKey{
+		child:      child,
+		childIndex: toAnyIndexer[Child](childIndex),
+		parent:     parent,
+		onDelete:   onDelete,
+	})
+}
+
+func (db *DB) addForeignKey(fk *foreignKey) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	state := db.state.Load()
+	for _, table := range []TableMeta{fk.child, fk.parent} {
+		if !state.root.isRegistered(table) {
+			return tableError(table.Name(), ErrTableNotRegistered)
+		}
+	}
+	if _, ok := state.root[fk.child.tablePos()].secondary[fk.childIndex.name]; !ok {
+		return tableError(fk.child.Name(), fmt.Errorf("index %q: %w", fk.childIndex.name, ErrIndexNotFound))
+	}
+
+	newState := state.withRoot(state.root)
+	newState.foreignKeys = append(slices.Clone(state.foreignKeys), fk)
+	db.state.Store(newState)
+	return nil
+}
+
+func (fk *foreignKey) String() string {
+	return fmt.Sprintf("%s(%s) -> %s", fk.child.Name(), fk.childIndex.name, fk.parent.Name())
+}
+
+// hasForeignKeys returns true if any of the foreign keys concern the tables
+// modified by the write transaction.
+func (txn *txn) hasForeignKeys(foreignKeys []*foreignKey) bool {
+	for _, fk := range foreignKeys {
+		if txn.isLocked(fk.child) || txn.isLocked(fk.parent) {
+			return true
+		}
+	}
+	return false
+}
+
+func (txn *txn) isLocked(meta TableMeta) bool {
+	pos := meta.tablePos()
+	return pos < len(txn.modifiedTables) && txn.modifiedTables[pos] != nil && txn.modifiedTables[pos].meta == meta
+}
+
+// checkForeignKeys checks the changes made in the write transaction against
+// the foreign keys and performs the cascading deletes. Called from Commit()
+// with the foreign key lock held to check against the latest committed state
+// of the tables not locked by this transaction.
+func (txn *txn) checkForeignKeys(state *dbState) error {
+	latest := newReadTxn(txn.db, state)
+
+	// Resolve the deleted parents first as the cascading deletes may delete the
+	// children inserted earlier in the transaction. The cascading deletes append
+	// to the changes and are resolved in turn.
+	for i := 0; i < len(txn.changes); i++ {
+		c := txn.changes[i]
+		if !c.deleted {
+			continue
+		}
+		for _, fk := range txn.foreignKeys {
+			if fk.parent == c.meta {
+				if err := txn.checkDeletedParent(latest, fk, c.obj); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, c := range txn.changes {
+		if c.deleted {
+			continue
+		}
+		for _, fk := range txn.foreignKeys {
+			if fk.child == c.meta {
+				if err := txn.checkInsertedChild(latest, fk, c.obj); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// view returns the transaction from which to read the table when checking
+// the foreign keys.
+func (txn *txn) view(latest *txn, meta TableMeta) *txn {
+	if txn.isLocked(meta) {
+		return txn
+	}
+	return latest
+}
+
+func (txn *txn) checkInsertedChild(latest *txn, fk *foreignKey, obj object) error {
+	// Only check the object if it is still the latest version of it.
+	idKey := fk.child.primary().fromObject(obj).First()
+	children, err := txn.indexReadTxn(fk.child, PrimaryIndexPos)
+	if err != nil {
+		return err
+	}
+	if current, found := children.Root().Get(idKey); !found || current.revision != obj.revision {
+		return nil
+	}
+
+	parents, err := txn.view(latest, fk.parent).indexReadTxn(fk.parent, PrimaryIndexPos)
+	if err != nil {
+		return err
+	}
+	fk.childIndex.fromObject(obj).Foreach(func(key index.Key) {
+		if _, found := parents.Root().Get(key); !found && err == nil {
+			err = tableError(fk.child.Name(),
+				fmt.Errorf("foreign key %s: object %q references missing object %q: %w",
+					fk, []byte(idKey), []byte(key), ErrForeignKeyViolation))
+		}
+	})
+	return err
+}
+
+func (txn *txn) checkDeletedParent(latest *txn, fk *foreignKey, obj object) error {
+	// Nothing to do if the object was inserted back.
+	idKey := fk.parent.primary().fromObject(obj).First()
+	parents, err := txn.indexReadTxn(fk.parent, PrimaryIndexPos)
+	if err != nil {
+		return err
+	}
+	if _, found := parents.Root().Get(idKey); found {
+		return nil
+	}
+
+	children, err := txn.view(latest, fk.child).indexReadTxnByName(fk.child, fk.childIndex.name)
+	if err != nil {
+		return err
+	}
+	var referencing []object
+	iter := children.Root().Iterator()
+	iter.SeekPrefix(idKey)
+	for key, child, ok := iter.Next(); ok; key, child, ok = iter.Next() {
+		if !children.unique {
+			_, key = decodeNonUniqueKey(key)
+		}
+		if bytes.Equal(key, idKey) {
+			referencing = append(referencing, child)
+		}
+	}
+	if len(referencing) == 0 {
+		return nil
+	}
+
+	if fk.onDelete != OnDeleteCascade {
+		return tableError(fk.parent.Name(),
+			fmt.Errorf("foreign key %s: deleted object %q is referenced by %d object(s): %w",
+				fk, []byte(idKey), len(referencing), ErrForeignKeyViolation))
+	}
+	if !txn.isLocked(fk.child) {
+		return tableError(fk.child.Name(),
+			fmt.Errorf("foreign key %s: cascading delete: %w", fk, ErrTableNotLockedForWriting))
+	}
+	for _, child := range referencing {
+		if _, _, err := txn.Delete(fk.child, 0, child.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
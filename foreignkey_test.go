@@ -0,0 +1,186 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package statedb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cilium/statedb/index"
+)
+
+type refObject struct {
+	ID     uint64
+	Parent uint64
+}
+
+var (
+	refIDIndex = Index[refObject, uint64]{
+		Name: "id",
+		FromObject: func(o refObject) index.KeySet {
+			return index.NewKeySet(index.Uint64(o.ID))
+		},
+		FromKey: index.Uint64,
+		Unique:  true,
+	}
+	refParentIndex = Index[refObject, uint64]{
+		Name: "parent",
+		FromObject: func(o refObject) index.KeySet {
+			return index.NewKeySet(index.Uint64(o.Parent))
+		},
+		FromKey: index.Uint64,
+		Unique:  false,
+	}
+)
+
+func newForeignKeyTestDB(t *testing.T, onDelete OnDeletePolicy) (*DB, RWTable[testObject], RWTable[refObject]) {
+	parents := MustNewTable[testObject]("parents", idIndex)
+	children := MustNewTable[refObject]("children", refIDIndex, refParentIndex)
+	db, err := NewDB([]TableMeta{parents, children}, NewExpVarMetrics(false))
+	require.NoError(t, err)
+	require.NoError(t, AddForeignKey(db, children, refParentIndex, parents, onDelete))
+	return db, parents, children
+}
+
+func TestForeignKey_Insert(t *testing.T) {
+	t.Parallel()
+
+	db, parents, children := newForeignKeyTestDB(t, OnDeleteReject)
+
+	require.ErrorIs(t,
+		AddForeignKey(db, children, refIDIndex, MustNewTable[testObject]("unregistered", idIndex), OnDeleteReject),
+		ErrTableNotRegistered)
+	require.ErrorIs(t,
+		AddForeignKey(db, children, Index[refObject, uint64]{Name: "missing", FromKey: index.Uint64}, parents, OnDeleteReject),
+		ErrIndexNotFound)
+
+	// Inserting a child referencing a missing parent fails.
+	wtxn := db.WriteTxn(children)
+	children.Insert(wtxn, refObject{ID: 1, Parent: 1})
+	err := wtxn.Commit()
+	require.ErrorIs(t, err, ErrForeignKeyViolation)
+	require.Zero(t, children.NumObjects(db.ReadTxn()))
+
+	// Committing the rejected transaction again is a no-op.
+	require.NoError(t, wtxn.Commit())
+
+	// Parent and child can be inserted in the same transaction.
+	wtxn = db.WriteTxn(parents, children)
+	children.Insert(wtxn, refObject{ID: 1, Parent: 1})
+	parents.Insert(wtxn, testObject{ID: 1})
+	require.NoError(t, wtxn.Commit())
+
+	// The parents are looked up from the latest committed state if the
+	// transaction does not lock the parent table.
+	wtxn = db.WriteTxn(children)
+	children.Insert(wtxn, refObject{ID: 2, Parent: 1})
+	require.NoError(t, wtxn.Commit())
+
+	// A child that was inserted and then fixed up or deleted within the
+	// transaction is fine.
+	wtxn = db.WriteTxn(children)
+	children.Insert(wtxn, refObject{ID: 3, Parent: 2})
+	children.Insert(wtxn, refObject{ID: 3, Parent: 1})
+	children.Insert(wtxn, refObject{ID: 4, Parent: 2})
+	children.Delete(wtxn, refObject{ID: 4})
+	require.NoError(t, wtxn.Commit())
+	require.Equal(t, 3, children.NumObjects(db.ReadTxn()))
+
+	// The index used by the foreign key cannot be dropped.
+	wtxn = db.WriteTxn(children)
+	require.ErrorIs(t, children.DropIndex(wtxn, refParentIndex.Name), ErrIndexReferenced)
+	wtxn.Abort()
+}
+
+func TestForeignKey_DeleteReject(t *testing.T) {
+	t.Parallel()
+
+	db, parents, children := newForeignKeyTestDB(t, OnDeleteReject)
+
+	wtxn := db.WriteTxn(parents, children)
+	parents.Insert(wtxn, testObject{ID: 1})
+	parents.Insert(wtxn, testObject{ID: 2})
+	children.Insert(wtxn, refObject{ID: 1, Parent: 1})
+	children.Insert(wtxn, refObject{ID: 2, Parent: 1})
+	require.NoError(t, wtxn.Commit())
+
+	// Deleting a referenced parent fails.
+	wtxn = db.WriteTxn(parents)
+	parents.Delete(wtxn, testObject{ID: 1})
+	require.ErrorIs(t, wtxn.Commit(), ErrForeignKeyViolation)
+	require.Equal(t, 2, parents.NumObjects(db.ReadTxn()))
+
+	// Unreferenced parents can be deleted and a deleted parent can be
+	// inserted back.
+	wtxn = db.WriteTxn(parents)
+	parents.Delete(wtxn, testObject{ID: 2})
+	parents.Delete(wtxn, testObject{ID: 1})
+	parents.Insert(wtxn, testObject{ID: 1, Tags: []string{"foo"}})
+	require.NoError(t, wtxn.Commit())
+
+	// Deleting the referencing children allows deleting the parent.
+	wtxn = db.WriteTxn(parents, children)
+	children.Delete(wtxn, refObject{ID: 1})
+	children.Delete(wtxn, refObject{ID: 2})
+	parents.Delete(wtxn, testObject{ID: 1})
+	require.NoError(t, wtxn.Commit())
+	require.Zero(t, parents.NumObjects(db.ReadTxn()))
+}
+
+func TestForeignKey_DeleteCascade(t *testing.T) {
+	t.Parallel()
+
+	db, parents, children := newForeignKeyTestDB(t, OnDeleteCascade)
+
+	// A grandchild table referencing the children.
+	grandchildren := MustNewTable[refObject]("grandchildren", refIDIndex, refParentIndex)
+	require.NoError(t, db.RegisterTable(grandchildren))
+	require.NoError(t, AddForeignKey(db, grandchildren, refParentIndex, children, OnDeleteCascade))
+
+	wtxn := db.WriteTxn(parents, children, grandchildren)
+	parents.Insert(wtxn, testObject{ID: 1})
+	parents.Insert(wtxn, testObject{ID: 2})
+	children.Insert(wtxn, refObject{ID: 1, Parent: 1})
+	children.Insert(wtxn, refObject{ID: 2, Parent: 1})
+	children.Insert(wtxn, refObject{ID: 3, Parent: 2})
+	grandchildren.Insert(wtxn, refObject{ID: 1, Parent: 2})
+	grandchildren.Insert(wtxn, refObject{ID: 2, Parent: 3})
+	require.NoError(t, wtxn.Commit())
+
+	// Cascading requires the referencing tables to be locked.
+	wtxn = db.WriteTxn(parents)
+	parents.Delete(wtxn, testObject{ID: 1})
+	require.ErrorIs(t, wtxn.Commit(), ErrTableNotLockedForWriting)
+
+	wtxn = db.WriteTxn(parents, children, grandchildren)
+	parents.Delete(wtxn, testObject{ID: 1})
+	require.NoError(t, wtxn.Commit())
+
+	txn := db.ReadTxn()
+	iter, _ := children.All(txn)
+	require.Equal(t, []refObject{{ID: 3, Parent: 2}}, Collect(iter))
+	iter, _ = grandchildren.All(txn)
+	require.Equal(t, []refObject{{ID: 2, Parent: 3}}, Collect(iter))
+
+	// A child inserted earlier in the transaction is deleted with its parent.
+	wtxn = db.WriteTxn(parents, children, grandchildren)
+	parents.Insert(wtxn, testObject{ID: 3})
+	require.NoError(t, wtxn.Commit())
+	wtxn = db.WriteTxn(parents, children, grandchildren)
+	children.Insert(wtxn, refObject{ID: 4, Parent: 3})
+	parents.Delete(wtxn, testObject{ID: 3})
+	require.NoError(t, wtxn.Commit())
+	iter, _ = children.All(db.ReadTxn())
+	require.Equal(t, []refObject{{ID: 3, Parent: 2}}, Collect(iter))
+
+	// A referenced table cannot be unregistered. The foreign keys of the
+	// referencing table are dropped with it.
+	require.ErrorIs(t, db.UnregisterTable(children), ErrTableReferenced)
+	require.NoError(t, db.UnregisterTable(grandchildren))
+	require.NoError(t, db.UnregisterTable(children))
+	wtxn = db.WriteTxn(parents)
+	parents.Delete(wtxn, testObject{ID: 2})
+	require.NoError(t, wtxn.Commit())
+}
@@ -40,6 +40,9 @@ func graveyardWorker(db *DB, ctx context.Context, gcRateLimitInterval time.Durat
 		// Do a lockless read transaction to find potential dead objects.
 		txn := db.ReadTxn().getTxn()
 		for _, table := range txn.root {
+			if table.meta == nil {
+				continue
+			}
 			tableName := table.meta.Name()
 			start := time.Now()
 
@@ -114,6 +117,9 @@ func graveyardWorker(db *DB, ctx context.Context, gcRateLimitInterval time.Durat
 		// Update object count metrics.
 		txn = db.ReadTxn().getTxn()
 		for _, table := range txn.root {
+			if table.meta == nil {
+				continue
+			}
 			name := table.meta.Name()
 			db.metrics.GraveyardObjectCount(string(name), table.numDeletedObjects())
 			db.metrics.ObjectCount(string(name), table.numObjects())
@@ -126,6 +132,9 @@ func graveyardWorker(db *DB, ctx context.Context, gcRateLimitInterval time.Durat
 func (db *DB) graveyardIsEmpty() bool {
 	txn := db.ReadTxn().getTxn()
 	for _, table := range txn.root {
+		if table.meta == nil {
+			continue
+		}
 		indexEntry := table.indexes[table.meta.indexPos(GraveyardIndex)]
 		if indexEntry.tree.Len() != 0 {
 			return false
@@ -0,0 +1,134 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package statedb
+
+import (
+	"time"
+)
+
+// history holds the retained past states of the database.
+type history struct {
+	maxCommits int
+	maxAge     time.Duration
+	entries    []historyEntry // ordered from oldest to newest
+	numChanges int            // sum of 'numChanges' of the entries
+}
+
+type historyEntry struct {
+	state       *dbState
+	committedAt time.Time
+	numChanges  int
+}
+
+func (h *history) enabled() bool {
+	return h.maxCommits > 0 || h.maxAge > 0
+}
+
+// prune removes the entries that are beyond the retention limits.
+func (h *history) prune(now time.Time) {
+	n := 0
+	for n < len(h.entries) {
+		entry := h.entries[n]
+		tooMany := h.maxCommits > 0 && len(h.entries)-n > h.maxCommits
+		tooOld := h.maxAge > 0 && now.Sub(entry.committedAt) > h.maxAge
+		if !tooMany && !tooOld {
+			break
+		}
+		h.numChanges -= entry.numChanges
+		n++
+	}
+	if n > 0 {
+		// Clear out the removed entries to not hold onto the old roots.
+		clear(h.entries[:n])
+		h.entries = h.entries[n:]
+	}
+}
+
+// HistoryEntry is a retained snapshot of the database. See DB.History.
+type HistoryEntry struct {
+	CommitID    uint64
+	CommittedAt time.Time
+
+	// Txn is a read transaction against the snapshot.
+	Txn ReadTxn
+}
+
+// SetHistoryRetention sets how many of the past snapshots of the database
+// are retained for queries with ReadTxnAt. A snapshot is retained until there
+// are more than 'maxCommits' newer ones or it is older than 'maxAge'. A zero
+// limit is not applied and with both limits zero no history is retained,
+// which is the default.
+//
+// As the snapshots are immutable and share the unchanged parts, retaining a
+// snapshot holds onto the objects and index nodes replaced after it. The
+// memory this takes is not measured. Instead the number of retained snapshots
+// and the number of inserts and deletes made by their commits are reported
+// with HistoryMetrics.HistoryRetained.
+func (db *DB) SetHistoryRetention(maxCommits int, maxAge time.Duration) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.history.maxCommits = maxCommits
+	db.history.maxAge = maxAge
+	if !db.history.enabled() {
+		db.history = history{}
+	}
+	db.pruneHistory()
+}
+
+// ReadTxnAt returns a read transaction against the snapshot of the database
+// produced by the commit with the given ID. Fails with ErrCommitNotRetained
+// if the snapshot is not the current one and it has not been retained in the
+// history. See ReadTxn.CommitID and SetHistoryRetention.
+func (db *DB) ReadTxnAt(commitID uint64) (ReadTxn, error) {
+	if state := db.state.Load(); state.commitID == commitID {
+		return newReadTxn(db, state), nil
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.pruneHistory()
+	for _, entry := range db.history.entries {
+		if entry.state.commitID == commitID {
+			return newReadTxn(db, entry.state), nil
+		}
+	}
+	return nil, ErrCommitNotRetained
+}
+
+// History returns the retained snapshots of the database ordered from the
+// oldest to the newest.
+func (db *DB) History() []HistoryEntry {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.pruneHistory()
+	entries := make([]HistoryEntry, len(db.history.entries))
+	for i, entry := range db.history.entries {
+		entries[i] = HistoryEntry{
+			CommitID:    entry.state.commitID,
+			CommittedAt: entry.committedAt,
+			Txn:         newReadTxn(db, entry.state),
+		}
+	}
+	return entries
+}
+
+// recordHistory adds the committed state into the history. Called from
+// Commit() with 'mu' held.
+func (db *DB) recordHistory(state *dbState, numChanges int) {
+	if !db.history.enabled() {
+		return
+	}
+	db.history.entries = append(db.history.entries, historyEntry{
+		state:       state,
+		committedAt: time.Now(),
+		numChanges:  numChanges,
+	})
+	db.history.numChanges += numChanges
+	db.pruneHistory()
+}
+
+func (db *DB) pruneHistory() {
+	db.history.prune(time.Now())
+	db.metrics.HistoryRetained(len(db.history.entries), db.history.numChanges)
+}
@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package statedb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_History(t *testing.T) {
+	t.Parallel()
+
+	db, table, metrics := newTestDB(t)
+
+	// No history is retained by default.
+	wtxn := db.WriteTxn(table)
+	table.Insert(wtxn, testObject{ID: 1})
+	wtxn.Commit()
+	require.Empty(t, db.History())
+	_, err := db.ReadTxnAt(0)
+	require.ErrorIs(t, err, ErrCommitNotRetained)
+
+	// The current snapshot is always available.
+	txn, err := db.ReadTxnAt(1)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), txn.CommitID())
+
+	db.SetHistoryRetention(3, 0)
+	for i := 2; i <= 6; i++ {
+		wtxn := db.WriteTxn(table)
+		table.Insert(wtxn, testObject{ID: uint64(i)})
+		table.Delete(wtxn, testObject{ID: uint64(i - 1)})
+		wtxn.Commit()
+	}
+
+	history := db.History()
+	require.Len(t, history, 3)
+	for i, entry := range history {
+		commitID := uint64(4 + i)
+		require.Equal(t, commitID, entry.CommitID)
+		require.Equal(t, commitID, entry.Txn.CommitID())
+		iter, _ := table.All(entry.Txn)
+		require.Equal(t, []testObject{{ID: commitID}}, Collect(iter))
+	}
+	require.Equal(t, "3/6", historyMetrics(metrics))
+
+	_, err = db.ReadTxnAt(3)
+	require.ErrorIs(t, err, ErrCommitNotRetained)
+	txn, err = db.ReadTxnAt(4)
+	require.NoError(t, err)
+	_, _, found := table.First(txn, idIndex.Query(4))
+	require.True(t, found)
+	_, _, found = table.First(txn, idIndex.Query(5))
+	require.False(t, found)
+
+	// Snapshots older than the maximum age are dropped.
+	db.SetHistoryRetention(0, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	require.Empty(t, db.History())
+	require.Equal(t, "0/0", historyMetrics(metrics))
+
+	// Disabling the history drops the retained snapshots.
+	db.SetHistoryRetention(10, 0)
+	wtxn = db.WriteTxn(table)
+	table.Insert(wtxn, testObject{ID: 100})
+	wtxn.Commit()
+	require.Len(t, db.History(), 1)
+	db.SetHistoryRetention(0, 0)
+	require.Empty(t, db.History())
+}
+
+func historyMetrics(m *ExpVarMetrics) string {
+	return m.HistoryRetainedVar.Get("commits").String() + "/" + m.HistoryRetainedVar.Get("changes").String()
+}
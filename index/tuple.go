@@ -0,0 +1,33 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package index
+
+// Tuple combines the keys into a composite key for an index on multiple
+// fields. Concatenating the keys directly would not preserve the order or
+// allow prefix searches when the keys are of variable length, e.g. ("a", "bc")
+// and ("ab", "c") would both be "abc". Instead each key is escaped (0x00 as
+// 0x00 0xff) and terminated (0x00 0x01). The composite keys then sort by the
+// first key, then by the second key and so on, and the composite key of the
+// leading keys is a prefix of the full composite key:
+//
+//	Tuple(String("a"), String("b")) < Tuple(String("a"), String("bc")) < Tuple(String("ab"), String(""))
+//	Tuple(String("a")) is a prefix of Tuple(String("a"), String("b"))
+func Tuple(keys ...Key) Key {
+	n := 0
+	for _, k := range keys {
+		n += len(k) + 2
+	}
+	buf := make([]byte, 0, n)
+	for _, k := range keys {
+		for _, b := range k {
+			if b == 0x00 {
+				buf = append(buf, 0x00, 0xff)
+			} else {
+				buf = append(buf, b)
+			}
+		}
+		buf = append(buf, 0x00, 0x01)
+	}
+	return buf
+}
@@ -0,0 +1,56 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package index_test
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cilium/statedb/index"
+)
+
+func TestTuple(t *testing.T) {
+	// The composite keys sort in the order of the tuples.
+	tuples := [][2]index.Key{
+		{index.String(""), index.String("")},
+		{index.String(""), index.String("a")},
+		{index.String("a"), index.String("")},
+		{index.String("a"), index.String("b")},
+		{index.String("a"), index.String("bc")},
+		{index.Key{'a', 0x00}, index.String("")},
+		{index.Key{'a', 0x00, 0x00}, index.String("")},
+		{index.Key{'a', 0x01}, index.String("")},
+		{index.String("ab"), index.String("")},
+		{index.Uint64(1), index.Uint16(2)},
+		{index.Uint64(1), index.Uint16(3)},
+		{index.Uint64(256), index.Uint16(0)},
+		{index.String("b"), index.String("a")},
+	}
+	slices.SortFunc(tuples, func(a, b [2]index.Key) int {
+		if c := bytes.Compare(a[0], b[0]); c != 0 {
+			return c
+		}
+		return bytes.Compare(a[1], b[1])
+	})
+	for i := 1; i < len(tuples); i++ {
+		prev := index.Tuple(tuples[i-1][0], tuples[i-1][1])
+		cur := index.Tuple(tuples[i][0], tuples[i][1])
+		require.Negative(t, bytes.Compare(prev, cur), "%x < %x", tuples[i-1], tuples[i])
+	}
+
+	// The composite key of the leading keys is a prefix only of the composite
+	// keys with the same leading keys.
+	for _, a := range tuples {
+		for _, b := range tuples {
+			prefix := bytes.HasPrefix(index.Tuple(b[0], b[1]), index.Tuple(a[0]))
+			require.Equal(t, a[0].Equal(b[0]), prefix, "%x, %x", a, b)
+		}
+	}
+
+	require.Empty(t, index.Tuple())
+	require.Equal(t, index.Key{'a', 0x00, 0xff, 0x00, 0x01, 0x00, 0x01}, index.Tuple(index.Key{'a', 0x00}, nil))
+}
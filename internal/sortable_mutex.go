@@ -4,6 +4,7 @@
 package internal
 
 import (
+	"context"
 	"sort"
 	"sync"
 	"sync/atomic"
@@ -16,18 +17,40 @@ var sortableMutexSeq atomic.Uint64
 
 // sortableMutex implements SortableMutex. Not exported as the only way to
 // initialize it is via NewSortableMutex().
+//
+// The mutex is implemented with a buffered channel rather than sync.Mutex
+// to allow giving up on acquiring it.
 type sortableMutex struct {
-	sync.Mutex
+	ch              chan struct{}
 	seq             uint64
 	acquireDuration time.Duration
 }
 
 func (s *sortableMutex) Lock() {
 	start := time.Now()
-	s.Mutex.Lock()
+	s.ch <- struct{}{}
 	s.acquireDuration = time.Since(start)
 }
 
+func (s *sortableMutex) LockContext(ctx context.Context) error {
+	start := time.Now()
+	select {
+	case s.ch <- struct{}{}:
+		s.acquireDuration = time.Since(start)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *sortableMutex) Unlock() {
+	select {
+	case <-s.ch:
+	default:
+		panic("unlock of unlocked SortableMutex")
+	}
+}
+
 func (s *sortableMutex) Seq() uint64 { return s.seq }
 
 func (s *sortableMutex) AcquireDuration() time.Duration { return s.acquireDuration }
@@ -37,6 +60,11 @@ func (s *sortableMutex) AcquireDuration() time.Duration { return s.acquireDurati
 // as it guarantees consistent lock ordering.
 type SortableMutex interface {
 	sync.Locker
+
+	// LockContext acquires the lock or fails with the context's error
+	// if the context is cancelled before the lock is acquired.
+	LockContext(ctx context.Context) error
+
 	Seq() uint64
 	AcquireDuration() time.Duration // The amount of time it took to acquire the lock
 }
@@ -69,6 +97,20 @@ func (s SortableMutexes) Lock() {
 	}
 }
 
+// LockContext sorts the mutexes, and then locks them in order. If the context is
+// cancelled before all the locks are acquired, the locks acquired so far are
+// released and the context's error is returned.
+func (s SortableMutexes) LockContext(ctx context.Context) error {
+	sort.Sort(s)
+	for i, mu := range s {
+		if err := mu.LockContext(ctx); err != nil {
+			s[:i].Unlock()
+			return err
+		}
+	}
+	return nil
+}
+
 // Unlock locks the sorted set of mutexes locked by prior call to Lock().
 func (s SortableMutexes) Unlock() {
 	for _, mu := range s {
@@ -81,6 +123,7 @@ var _ sort.Interface = SortableMutexes{}
 func NewSortableMutex() SortableMutex {
 	seq := sortableMutexSeq.Add(1)
 	return &sortableMutex{
+		ch:  make(chan struct{}, 1),
 		seq: seq,
 	}
 }
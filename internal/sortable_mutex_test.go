@@ -4,6 +4,7 @@
 package internal
 
 import (
+	"context"
 	"math/rand"
 	"slices"
 	"sync"
@@ -28,6 +29,36 @@ func TestSortableMutex(t *testing.T) {
 	smus.Unlock()
 }
 
+func TestSortableMutex_LockContext(t *testing.T) {
+	smu1 := NewSortableMutex()
+	smu2 := NewSortableMutex()
+	smu3 := NewSortableMutex()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	smus := SortableMutexes{smu3, smu1}
+	require.NoError(t, smus.LockContext(context.Background()))
+
+	// Locking fails as smu1 is held.
+	require.ErrorIs(t, SortableMutexes{smu2, smu1}.LockContext(ctx), context.Canceled)
+
+	// Locking fails on smu3 after acquiring smu1 and smu2, which are released.
+	smu1.Unlock()
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, SortableMutexes{smu3, smu2, smu1}.LockContext(ctx), context.DeadlineExceeded)
+	smu1.Lock()
+	smu2.Lock()
+	smu3.Unlock()
+	SortableMutexes{smu1, smu2}.Unlock()
+
+	smus = SortableMutexes{smu1, smu2, smu3}
+	require.NoError(t, smus.LockContext(context.Background()))
+	smus.Unlock()
+
+	require.Panics(t, smu1.Unlock)
+}
+
 func TestSortableMutex_Chaos(t *testing.T) {
 	smus := SortableMutexes{
 		NewSortableMutex(),
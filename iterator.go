@@ -6,6 +6,8 @@ package statedb
 import (
 	"bytes"
 	"fmt"
+
+	iradix "github.com/hashicorp/go-immutable-radix/v2"
 )
 
 // Collect creates a slice of objects out of the iterator.
@@ -43,6 +45,32 @@ func (it *iterator[Obj]) Next() (obj Obj, revision uint64, ok bool) {
 	return
 }
 
+// FromSlice returns an iterator over the objects in the slice, e.g. for
+// InsertMany. The revisions of the objects are zero.
+func FromSlice[Obj any](objs []Obj) Iterator[Obj] {
+	return &sliceIterator[Obj]{objs: objs}
+}
+
+// sliceIterator iterates over objects and their revisions held in slices.
+// If 'revs' is nil the revisions are zero.
+type sliceIterator[Obj any] struct {
+	objs []Obj
+	revs []Revision
+}
+
+func (it *sliceIterator[Obj]) Next() (obj Obj, revision Revision, ok bool) {
+	if len(it.objs) == 0 {
+		return
+	}
+	obj, ok = it.objs[0], true
+	it.objs = it.objs[1:]
+	if it.revs != nil {
+		revision = it.revs[0]
+		it.revs = it.revs[1:]
+	}
+	return
+}
+
 // Map applies a function to transform every object returned by the iterator
 func Map[In, Out any, It Iterator[In]](iter It, transform func(In) Out) Iterator[Out] {
 	return &mapIterator[In, Out]{
@@ -90,6 +118,16 @@ func (it *filterIterator[Obj]) Next() (out Obj, revision Revision, ok bool) {
 	return
 }
 
+// reverseIterator adapts the reverse iterator of the index to iterate
+// with Next() in descending key order.
+type reverseIterator struct {
+	iter *iradix.ReverseIterator[object]
+}
+
+func (it reverseIterator) Next() ([]byte, object, bool) {
+	return it.iter.Previous()
+}
+
 // uniqueIterator iterates over objects in a unique index. Since
 // we find the node by prefix search, we may see a key that shares
 // the search prefix but is longer. We skip those objects.
@@ -206,3 +244,58 @@ func (it *DualIterator[Obj]) Next() (obj Obj, revision uint64, fromLeft, ok bool
 		panic(fmt.Sprintf("BUG: Unhandled case: %+v", it))
 	}
 }
+
+// rangeIterator iterates over the objects with keys in the half-open range
+// [from, to). For non-unique indexes the secondary keys are compared.
+type rangeIterator[Obj any] struct {
+	iter     interface{ Next() ([]byte, object, bool) }
+	from, to []byte
+	unique   bool
+
+	// For a non-unique index the objects whose secondary key is a prefix
+	// of 'to' sort after the other objects in the range. If 'hasTail' is
+	// true the objects with 'tail' as key prefix are iterated beyond 'to'
+	// to find them.
+	tail    []byte
+	hasTail bool
+	done    bool
+}
+
+func (it *rangeIterator[Obj]) Next() (obj Obj, revision uint64, ok bool) {
+	for !it.done {
+		key, iobj, found := it.iter.Next()
+		if !found || (bytes.Compare(key, it.to) >= 0 && !(it.hasTail && bytes.HasPrefix(key, it.tail))) {
+			it.done = true
+			break
+		}
+		if !it.unique {
+			_, secondary := decodeNonUniqueKey(key)
+			if bytes.Compare(secondary, it.from) < 0 || bytes.Compare(secondary, it.to) >= 0 {
+				continue
+			}
+		}
+		return iobj.data.(Obj), iobj.revision, true
+	}
+	return
+}
+
+// upperBoundIterator iterates over a non-unique index in descending order
+// skipping the objects with a secondary key greater than the upper bound. These
+// are interleaved with the others as the keys are suffixed by the primary key.
+type upperBoundIterator[Obj any] struct {
+	iter interface{ Next() ([]byte, object, bool) }
+	key  []byte
+}
+
+func (it *upperBoundIterator[Obj]) Next() (obj Obj, revision uint64, ok bool) {
+	for {
+		key, iobj, found := it.iter.Next()
+		if !found {
+			return
+		}
+		_, secondary := decodeNonUniqueKey(key)
+		if bytes.Compare(secondary, it.key) <= 0 {
+			return iobj.data.(Obj), iobj.revision, true
+		}
+	}
+}
@@ -0,0 +1,421 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package statedb
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cilium/statedb/index"
+)
+
+const (
+	// defaultJournalSegmentSize is the default size after which a new
+	// journal segment is started.
+	defaultJournalSegmentSize = 64 * 1024 * 1024
+
+	journalSegmentSuffix = ".wal"
+
+	journalOpInsert = byte(1)
+	journalOpDelete = byte(2)
+)
+
+// Journal is a write-ahead log of the changes made by committed write
+// transactions. When set on the database with DB.SetJournal, every insert
+// and delete is appended to the journal on Commit() before the changes are
+// made visible to readers. Commit fails if the changes cannot be appended,
+// and after a failure to write or closing the journal all commits appending
+// to it fail. The journal consists of segment files that are
+// started anew when the current segment grows beyond the segment size.
+//
+// To recover the database, restore the latest snapshot with DB.Restore
+// and then apply the changes made after it with Replay:
+//
+//	journal, err := statedb.OpenJournal(dir, 0)
+//	...
+//	db.Restore(snapshotFile)
+//	journal.Replay(db)
+//	db.SetJournal(journal)
+//
+// Segments that only contain changes already included in a snapshot are
+// removed with Compact.
+//
+// The journal segments are written without syncing them to disk on each
+// commit. The changes thus survive the process crashing, but not the
+// machine crashing unless Sync() is called.
+type Journal struct {
+	dir         string
+	segmentSize int64
+
+	mu       sync.Mutex
+	err      error             // sticky write error
+	segments []*journalSegment // segments ordered from oldest to newest, last one being written to
+	file     *os.File
+}
+
+type journalSegment struct {
+	seq  uint64
+	size int64
+
+	// revisions is the highest revision of each table in the segment.
+	revisions map[TableName]Revision
+}
+
+func (s *journalSegment) path(dir string) string {
+	return filepath.Join(dir, fmt.Sprintf("%016x%s", s.seq, journalSegmentSuffix))
+}
+
+// OpenJournal opens the journal in the given directory, creating the directory
+// if it does not exist. A new segment is always started for the changes written
+// via this journal. If 'segmentSize' is zero the default of 64MiB is used.
+func OpenJournal(dir string, segmentSize int64) (*Journal, error) {
+	if segmentSize <= 0 {
+		segmentSize = defaultJournalSegmentSize
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	j := &Journal{
+		dir:         dir,
+		segmentSize: segmentSize,
+	}
+
+	// Find the existing segments and the revisions they contain.
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, dirEntry := range dirEntries {
+		name, ok := strings.CutSuffix(dirEntry.Name(), journalSegmentSuffix)
+		if !ok || dirEntry.IsDir() {
+			continue
+		}
+		seq, err := strconv.ParseUint(name, 16, 64)
+		if err != nil {
+			continue
+		}
+		seg := &journalSegment{seq: seq, revisions: map[TableName]Revision{}}
+		err = j.readSegment(seg, func(table TableName, _ bool, revision Revision, _ []byte) error {
+			seg.revisions[table] = max(seg.revisions[table], revision)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		j.segments = append(j.segments, seg)
+	}
+	slices.SortFunc(j.segments, func(a, b *journalSegment) int {
+		return cmp.Compare(a.seq, b.seq)
+	})
+
+	if err := j.startSegment(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// startSegment closes the current segment file and creates a new one.
+func (j *Journal) startSegment() error {
+	seq := uint64(0)
+	if len(j.segments) > 0 {
+		seq = j.segments[len(j.segments)-1].seq + 1
+	}
+	seg := &journalSegment{seq: seq, revisions: map[TableName]Revision{}}
+	f, err := os.OpenFile(seg.path(j.dir), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	if j.file != nil {
+		j.file.Close()
+	}
+	j.file = f
+	j.segments = append(j.segments, seg)
+	return nil
+}
+
+// append writes the changes of a write transaction as a single record into
+// the journal. Called from Commit() while holding the table locks, which
+// guarantees that the changes to a table are appended in revision order.
+// After a failure to write, no further changes are appended.
+func (j *Journal) append(entries []change) error {
+	var buf bytes.Buffer
+	rw := newRecordWriter(&buf)
+
+	// Reserve space for the record header: length and checksum of the payload.
+	rw.w.Write(make([]byte, 8))
+	rw.writeUvarint(uint64(len(entries)))
+	for _, e := range entries {
+		data, err := e.meta.encodeObject(e.obj.data)
+		if err != nil {
+			return tableError(e.meta.Name(), err)
+		}
+		op := journalOpInsert
+		if e.deleted {
+			op = journalOpDelete
+		}
+		rw.w.WriteByte(op)
+		rw.writeString(e.meta.Name())
+		rw.writeBytes(e.meta.primary().fromObject(e.obj).First())
+		rw.writeUvarint(e.obj.revision)
+		rw.writeBytes(data)
+	}
+	rw.flush()
+	record := buf.Bytes()
+	payload := record[8:]
+	if len(payload) > maxRecordSize {
+		return fmt.Errorf("journal record of %d bytes exceeds the maximum record size", len(payload))
+	}
+	binary.BigEndian.PutUint32(record[0:], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:], crc32.ChecksumIEEE(payload))
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.err != nil {
+		return j.err
+	}
+	if _, err := j.file.Write(record); err != nil {
+		j.err = err
+		return err
+	}
+	seg := j.segments[len(j.segments)-1]
+	seg.size += int64(len(record))
+	for _, e := range entries {
+		name := e.meta.Name()
+		seg.revisions[name] = max(seg.revisions[name], e.obj.revision)
+	}
+	if seg.size >= j.segmentSize {
+		// The record has been written and thus the failure to start the
+		// next segment only fails the later appends.
+		j.err = j.startSegment()
+	}
+	return nil
+}
+
+// Err returns the error that occurred when writing to the journal. After
+// a failure no further changes are written into the journal and the commits
+// of the write transactions that would append to it fail.
+func (j *Journal) Err() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.err
+}
+
+// Sync commits the current segment to stable storage.
+func (j *Journal) Sync() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.err != nil {
+		return j.err
+	}
+	return j.file.Sync()
+}
+
+// Close the journal. The database must not be modified after the
+// journal has been closed.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	if j.err == nil {
+		j.err = errors.New("journal closed")
+	}
+	return err
+}
+
+// Compact removes the segments in which all changes are at or below the
+// table revisions in the given transaction. To not lose any changes the
+// transaction must be the one from which the latest snapshot was taken or
+// older, e.g. a ReadTxn created before calling DB.Snapshot. The segment
+// currently being written to is never removed.
+func (j *Journal) Compact(txn ReadTxn) error {
+	root := txn.getTxn().root
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	current := j.segments[len(j.segments)-1]
+	var errs []error
+	j.segments = slices.DeleteFunc(j.segments, func(seg *journalSegment) bool {
+		if seg == current {
+			return false
+		}
+		for name, rev := range seg.revisions {
+			meta := root.tableMeta(name)
+			if meta == nil || root[meta.tablePos()].revision < rev {
+				return false
+			}
+		}
+		if err := os.Remove(seg.path(j.dir)); err != nil {
+			errs = append(errs, err)
+			return false
+		}
+		return true
+	})
+	return errors.Join(errs...)
+}
+
+// Replay applies the changes in the journal that are newer than the
+// current revisions of the tables. The changes retain the revisions they
+// had when they were committed. Replay is meant to be used on startup after
+// restoring the latest snapshot (DB.Restore) and before the journal is set
+// on the database and before the database is used.
+func (j *Journal) Replay(db *DB) error {
+	root := db.state.Load().root
+	tables := root.tables()
+	if len(tables) == 0 {
+		return nil
+	}
+	txn := db.WriteTxn(tables[0], tables[1:]...).getTxn()
+	defer txn.Abort()
+
+	// The replayed changes are already in the journal.
+	txn.journal = nil
+
+	j.mu.Lock()
+	segments := slices.Clone(j.segments)
+	j.mu.Unlock()
+
+	for _, seg := range segments {
+		err := j.readSegment(seg, func(name TableName, deleted bool, revision Revision, data []byte) error {
+			meta := root.tableMeta(name)
+			if meta == nil {
+				return tableError(name, ErrTableNotRegistered)
+			}
+			table := txn.modifiedTables[meta.tablePos()]
+			if revision <= table.revision {
+				// Already included in the restored snapshot.
+				return nil
+			}
+			obj, err := meta.decodeObject(data)
+			if err != nil {
+				return tableError(name, err)
+			}
+
+			// Insert and Delete allocate the next revision, so rewind
+			// the table revision to have the change retain its revision.
+			table.revision = revision - 1
+			if !deleted {
+				_, _, err = txn.Insert(meta, 0, obj)
+				return err
+			}
+			old, existed, err := txn.Delete(meta, 0, obj)
+			if err == nil && existed && !txn.hasDeleteTrackers(meta) {
+				// Keep the deleted object in the graveyard for delete
+				// trackers resuming from a revision prior to the deletion.
+				// See DB.Restore.
+				old.revision = revision
+				idKey := meta.primary().fromObject(old).First()
+				txn.mustIndexWriteTxn(meta, GraveyardIndexPos).Insert(idKey, old)
+				txn.mustIndexWriteTxn(meta, GraveyardRevisionIndexPos).Insert(index.Uint64(revision), old)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return txn.Commit()
+}
+
+// readSegment reads all the entries in the segment. Reading stops at the
+// first truncated or corrupted record, which may happen if the process
+// crashed while appending to the segment.
+func (j *Journal) readSegment(seg *journalSegment, fn func(table TableName, deleted bool, revision Revision, data []byte) error) error {
+	f, err := os.Open(seg.path(j.dir))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	remaining := info.Size()
+	rr := newRecordReader(f, ErrCorruptJournal)
+
+	// A record torn by a crash while appending is tolerated only at the end
+	// of the segment. A segment is never appended to after reopening the
+	// journal and thus any segment may end with a torn record.
+	var header [8]byte
+	for {
+		if remaining < int64(len(header)) {
+			// End of segment or a torn header.
+			return nil
+		}
+		if _, err := io.ReadFull(rr.r, header[:]); err != nil {
+			return err
+		}
+		remaining -= int64(len(header))
+
+		// Check the length before allocating the payload.
+		size := binary.BigEndian.Uint32(header[0:])
+		if size > maxRecordSize {
+			return fmt.Errorf("%w: %s: record length %d exceeds the maximum record size",
+				ErrCorruptJournal, seg.path(j.dir), size)
+		}
+		if int64(size) > remaining {
+			// Torn payload.
+			return nil
+		}
+		remaining -= int64(size)
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(rr.r, payload); err != nil {
+			return err
+		}
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+			if remaining == 0 {
+				// Torn payload of the last record.
+				return nil
+			}
+			return fmt.Errorf("%w: %s: checksum mismatch in a record followed by %d bytes",
+				ErrCorruptJournal, seg.path(j.dir), remaining)
+		}
+
+		prr := newRecordReader(bytes.NewReader(payload), ErrCorruptJournal)
+		numEntries, err := prr.readUvarint()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < numEntries; i++ {
+			op, err := prr.r.ReadByte()
+			if err != nil {
+				return unexpectedEOF(err)
+			}
+			name, err := prr.readString()
+			if err != nil {
+				return err
+			}
+			// Skip the primary key.
+			if _, err := prr.readBytes(); err != nil {
+				return err
+			}
+			revision, err := prr.readUvarint()
+			if err != nil {
+				return err
+			}
+			data, err := prr.readBytes()
+			if err != nil {
+				return err
+			}
+			if err := fn(name, op == journalOpDelete, revision, data); err != nil {
+				return err
+			}
+		}
+	}
+}
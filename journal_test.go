@@ -0,0 +1,324 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package statedb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func requireSameObjects(t *testing.T, db *DB, table RWTable[testObject], db2 *DB, table2 RWTable[testObject]) {
+	txn, txn2 := db.ReadTxn(), db2.ReadTxn()
+	require.Equal(t, table.Revision(txn), table2.Revision(txn2), "Revision")
+	require.Equal(t, table.NumObjects(txn), table2.NumObjects(txn2), "NumObjects")
+	iter, _ := table.All(txn)
+	for obj, rev, ok := iter.Next(); ok; obj, rev, ok = iter.Next() {
+		obj2, rev2, found := table2.First(txn2, idIndex.Query(obj.ID))
+		require.True(t, found, "object %d not found", obj.ID)
+		require.Equal(t, obj, obj2)
+		require.Equal(t, rev, rev2)
+	}
+}
+
+func TestJournal_Replay(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	db, table, _ := newTestDB(t, tagsIndex)
+	journal, err := OpenJournal(dir, 256)
+	require.NoError(t, err)
+	db.SetJournal(journal)
+
+	for i := 1; i <= 20; i++ {
+		wtxn := db.WriteTxn(table)
+		_, _, err := table.Insert(wtxn, testObject{ID: uint64(i), Tags: []string{"foo"}})
+		require.NoError(t, err)
+		if i%3 == 0 {
+			_, _, err := table.Delete(wtxn, testObject{ID: uint64(i - 1)})
+			require.NoError(t, err)
+		}
+		wtxn.Commit()
+	}
+
+	// Aborted changes are not written into the journal.
+	wtxn := db.WriteTxn(table)
+	table.Insert(wtxn, testObject{ID: 100})
+	wtxn.Abort()
+
+	require.NoError(t, journal.Err())
+	require.NoError(t, journal.Close())
+
+	segments, err := filepath.Glob(filepath.Join(dir, "*.wal"))
+	require.NoError(t, err)
+	require.Greater(t, len(segments), 1, "expected multiple segments")
+
+	// Replay the journal into an empty database.
+	db2, table2, _ := newTestDB(t, tagsIndex)
+	journal2, err := OpenJournal(dir, 256)
+	require.NoError(t, err)
+	require.NoError(t, journal2.Replay(db2))
+	requireSameObjects(t, db, table, db2, table2)
+
+	iter, _ := table2.Get(db2.ReadTxn(), tagsIndex.Query("foo"))
+	require.Len(t, Collect(iter), table.NumObjects(db.ReadTxn()))
+
+	// Continue writing to the replayed database via the journal and
+	// then recover from a snapshot and the journal after compacting.
+	db2.SetJournal(journal2)
+	wtxn = db2.WriteTxn(table2)
+	table2.Insert(wtxn, testObject{ID: 200})
+	wtxn.Commit()
+
+	txn := db2.ReadTxn()
+	var snapshot bytes.Buffer
+	require.NoError(t, db2.Snapshot(&snapshot))
+	require.NoError(t, journal2.Compact(txn))
+
+	segments, err = filepath.Glob(filepath.Join(dir, "*.wal"))
+	require.NoError(t, err)
+	require.Len(t, segments, 1, "expected only the current segment to remain")
+
+	wtxn = db2.WriteTxn(table2)
+	table2.Insert(wtxn, testObject{ID: 201})
+	table2.Delete(wtxn, testObject{ID: 1})
+	wtxn.Commit()
+	require.NoError(t, journal2.Close())
+
+	db3, table3, _ := newTestDB(t, tagsIndex)
+	journal3, err := OpenJournal(dir, 256)
+	require.NoError(t, err)
+	require.NoError(t, db3.Restore(&snapshot))
+	require.NoError(t, journal3.Replay(db3))
+	require.NoError(t, journal3.Close())
+	requireSameObjects(t, db2, table2, db3, table3)
+}
+
+func TestJournal_ReplayCommitError(t *testing.T) {
+	t.Parallel()
+
+	// Write a child referencing a missing parent into the journal from a
+	// database without the foreign key.
+	dir := t.TempDir()
+	children := MustNewTable[refObject]("children", refIDIndex, refParentIndex)
+	db, err := NewDB([]TableMeta{MustNewTable[testObject]("parents", idIndex), children}, NewExpVarMetrics(false))
+	require.NoError(t, err)
+	journal, err := OpenJournal(dir, 0)
+	require.NoError(t, err)
+	db.SetJournal(journal)
+	wtxn := db.WriteTxn(children)
+	children.Insert(wtxn, refObject{ID: 1, Parent: 1})
+	require.NoError(t, wtxn.Commit())
+	require.NoError(t, journal.Close())
+
+	// Replaying it violates the foreign key and nothing is committed.
+	db2, _, children2 := newForeignKeyTestDB(t, OnDeleteReject)
+	journal2, err := OpenJournal(dir, 0)
+	require.NoError(t, err)
+	require.ErrorIs(t, journal2.Replay(db2), ErrForeignKeyViolation)
+	require.NoError(t, journal2.Close())
+	require.Zero(t, children2.NumObjects(db2.ReadTxn()))
+}
+
+func TestJournal_ReplayEviction(t *testing.T) {
+	t.Parallel()
+
+	newDB := func() (*DB, RWTable[testObject]) {
+		table, err := NewTableWithOptions[testObject]("test", idIndex, nil,
+			WithCapacity[testObject](1, EvictOldest))
+		require.NoError(t, err)
+		db, err := NewDB([]TableMeta{table}, NewExpVarMetrics(false))
+		require.NoError(t, err)
+		return db, table
+	}
+
+	// The eviction is recorded before the insert that caused it.
+	dir := t.TempDir()
+	db, table := newDB()
+	journal, err := OpenJournal(dir, 0)
+	require.NoError(t, err)
+	db.SetJournal(journal)
+	wtxn := db.WriteTxn(table)
+	table.Insert(wtxn, testObject{ID: 1})
+	require.NoError(t, wtxn.Commit())
+	wtxn = db.WriteTxn(table)
+	table.Insert(wtxn, testObject{ID: 2})
+	require.NoError(t, wtxn.Commit())
+	require.NoError(t, journal.Close())
+
+	db2, table2 := newDB()
+	journal2, err := OpenJournal(dir, 0)
+	require.NoError(t, err)
+	require.NoError(t, journal2.Replay(db2))
+	require.NoError(t, journal2.Close())
+	requireSameObjects(t, db, table, db2, table2)
+	iter, _ := table2.All(db2.ReadTxn())
+	require.Equal(t, []testObject{{ID: 2}}, Collect(iter))
+}
+
+// failingCodec fails to encode the object with the ID 2.
+type failingCodec struct {
+	JSONCodec[testObject]
+}
+
+func (c failingCodec) Encode(obj testObject) ([]byte, error) {
+	if obj.ID == 2 {
+		return nil, errors.New("encode failed")
+	}
+	return c.JSONCodec.Encode(obj)
+}
+
+func TestJournal_AppendFailure(t *testing.T) {
+	t.Parallel()
+
+	table, err := NewTableWithOptions[testObject]("test", idIndex, nil,
+		WithCodec[testObject](failingCodec{}))
+	require.NoError(t, err)
+	db, err := NewDB([]TableMeta{table}, NewExpVarMetrics(false))
+	require.NoError(t, err)
+	journal, err := OpenJournal(t.TempDir(), 0)
+	require.NoError(t, err)
+	db.SetJournal(journal)
+
+	insert := func(id uint64) (aborted bool, err error) {
+		wtxn := db.WriteTxn(table)
+		wtxn.OnAbort(func() { aborted = true })
+		table.Insert(wtxn, testObject{ID: id})
+		err = wtxn.Commit()
+		return
+	}
+
+	// A transaction that cannot be appended to the journal is aborted.
+	aborted, err := insert(1)
+	require.NoError(t, err)
+	require.False(t, aborted)
+	aborted, err = insert(2)
+	require.ErrorContains(t, err, "encode failed")
+	require.True(t, aborted)
+	require.NoError(t, journal.Err())
+	_, err = insert(3)
+	require.NoError(t, err)
+
+	// After a failure to write the commits fail.
+	require.NoError(t, journal.Close())
+	aborted, err = insert(4)
+	require.Error(t, err)
+	require.True(t, aborted)
+
+	iter, _ := table.All(db.ReadTxn())
+	require.Equal(t, []uint64{1, 3}, objectIDs(Collect(iter)))
+}
+
+func TestJournal_TruncatedSegment(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	db, table, _ := newTestDB(t)
+	journal, err := OpenJournal(dir, 0)
+	require.NoError(t, err)
+	db.SetJournal(journal)
+
+	for i := 1; i <= 2; i++ {
+		wtxn := db.WriteTxn(table)
+		table.Insert(wtxn, testObject{ID: uint64(i)})
+		wtxn.Commit()
+	}
+	require.NoError(t, journal.Close())
+
+	// Cut the last record in half to simulate a crash while appending.
+	segments, err := filepath.Glob(filepath.Join(dir, "*.wal"))
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+	info, err := os.Stat(segments[0])
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(segments[0], info.Size()-3))
+
+	db2, table2, _ := newTestDB(t)
+	journal2, err := OpenJournal(dir, 0)
+	require.NoError(t, err)
+	require.NoError(t, journal2.Replay(db2))
+	require.NoError(t, journal2.Close())
+
+	txn := db2.ReadTxn()
+	require.Equal(t, 1, table2.NumObjects(txn))
+	_, _, found := table2.First(txn, idIndex.Query(1))
+	require.True(t, found)
+}
+
+func TestJournal_CorruptSegment(t *testing.T) {
+	t.Parallel()
+
+	// A record length beyond the maximum is rejected before allocating.
+	dir := t.TempDir()
+	seg := &journalSegment{seq: 0}
+	header := []byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0}
+	require.NoError(t, os.WriteFile(seg.path(dir), header, 0o640))
+	_, err := OpenJournal(dir, 0)
+	require.ErrorIs(t, err, ErrCorruptJournal)
+
+	// A record length beyond the end of the segment is a truncated record.
+	dir = t.TempDir()
+	header = []byte{0x00, 0x10, 0x00, 0x00, 0, 0, 0, 0, 1, 2, 3}
+	require.NoError(t, os.WriteFile(seg.path(dir), header, 0o640))
+	journal, err := OpenJournal(dir, 0)
+	require.NoError(t, err)
+	require.NoError(t, journal.Close())
+}
+
+func TestJournal_CorruptRecord(t *testing.T) {
+	t.Parallel()
+
+	// Write three records into the first segment and then reopen the journal
+	// to start the next one.
+	dir := t.TempDir()
+	db, table, _ := newTestDB(t)
+	journal, err := OpenJournal(dir, 0)
+	require.NoError(t, err)
+	db.SetJournal(journal)
+	for i := 1; i <= 3; i++ {
+		wtxn := db.WriteTxn(table)
+		table.Insert(wtxn, testObject{ID: uint64(i)})
+		require.NoError(t, wtxn.Commit())
+	}
+	require.NoError(t, journal.Close())
+	journal, err = OpenJournal(dir, 0)
+	require.NoError(t, err)
+	require.NoError(t, journal.Close())
+
+	path := (&journalSegment{seq: 0}).path(dir)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	recordEnd := func(offset int) int {
+		return offset + 8 + int(binary.BigEndian.Uint32(data[offset:]))
+	}
+	second := recordEnd(0)
+	third := recordEnd(second)
+	corrupt := func(offset int) {
+		corrupted := slices.Clone(data)
+		corrupted[offset] ^= 0xff
+		require.NoError(t, os.WriteFile(path, corrupted, 0o640))
+	}
+
+	// A corrupt record followed by more records is rejected.
+	corrupt(second + 8)
+	_, err = OpenJournal(dir, 0)
+	require.ErrorIs(t, err, ErrCorruptJournal)
+	require.ErrorContains(t, err, path)
+
+	// A corrupt last record is a torn write and is skipped.
+	corrupt(third + 8)
+	db2, table2, _ := newTestDB(t)
+	journal2, err := OpenJournal(dir, 0)
+	require.NoError(t, err)
+	require.NoError(t, journal2.Replay(db2))
+	require.NoError(t, journal2.Close())
+	iter, _ := table2.All(db2.ReadTxn())
+	require.Equal(t, []uint64{1, 2}, objectIDs(Collect(iter)))
+}
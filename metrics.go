@@ -7,6 +7,10 @@ import (
 	"time"
 )
 
+// Metrics is the interface for reporting the database metrics. The metrics
+// added after it was introduced are reported via the optional interfaces
+// HistoryMetrics, LockWatchdogMetrics and CapacityMetrics if the implementation
+// also implements them.
 type Metrics interface {
 	WriteTxnTableAcquisition(handle string, tableName string, acquire time.Duration)
 	WriteTxnTotalAcquisition(handle string, tables []string, acquire time.Duration)
@@ -21,6 +25,57 @@ type Metrics interface {
 	Revision(tableName string, revision Revision)
 }
 
+// HistoryMetrics is optionally implemented by Metrics to report the amount of
+// retained history. See DB.SetHistoryRetention.
+type HistoryMetrics interface {
+	// HistoryRetained reports the number of retained historical snapshots and
+	// the total number of inserts and deletes made by the commits that
+	// produced them. These are counts and not a measure of memory use.
+	HistoryRetained(numCommits int, numChanges int)
+}
+
+// LockWatchdogMetrics is optionally implemented by Metrics to report the write
+// transactions holding the table locks for too long. See DB.SetLockWatchdog.
+type LockWatchdogMetrics interface {
+	// WriteTxnLongHeld is called when a write transaction has held the table
+	// locks for longer than the threshold set with DB.SetLockWatchdog.
+	WriteTxnLongHeld(handle string, tables []string, held time.Duration)
+}
+
+// CapacityMetrics is optionally implemented by Metrics to report the evictions
+// and rejections of objects in tables with a capacity. See WithCapacity.
+type CapacityMetrics interface {
+	// CapacityEviction is called when an object is evicted from a table at
+	// its capacity to make room for a new object. CapacityRejection is called
+	// when an insert fails with ErrTableFull.
+	CapacityEviction(tableName string)
+	CapacityRejection(tableName string)
+}
+
+// dbMetrics is Metrics together with the optional metrics interfaces, which
+// are no-ops if not implemented.
+type dbMetrics struct {
+	Metrics
+	HistoryMetrics
+	LockWatchdogMetrics
+	CapacityMetrics
+}
+
+func newDBMetrics(m Metrics) dbMetrics {
+	nop := &NopMetrics{}
+	dm := dbMetrics{m, nop, nop, nop}
+	if hm, ok := m.(HistoryMetrics); ok {
+		dm.HistoryMetrics = hm
+	}
+	if lm, ok := m.(LockWatchdogMetrics); ok {
+		dm.LockWatchdogMetrics = lm
+	}
+	if cm, ok := m.(CapacityMetrics); ok {
+		dm.CapacityMetrics = cm
+	}
+	return dm
+}
+
 // ExpVarMetrics is a simple implementation for the metrics.
 type ExpVarMetrics struct {
 	LockContentionVar            *expvar.Map
@@ -30,8 +85,12 @@ type ExpVarMetrics struct {
 	ObjectCountVar               *expvar.Map
 	WriteTxnAcquisitionVar       *expvar.Map
 	WriteTxnDurationVar          *expvar.Map
+	WriteTxnLongHeldVar          *expvar.Map
 	DeleteTrackerCountVar        *expvar.Map
 	RevisionVar                  *expvar.Map
+	HistoryRetainedVar           *expvar.Map
+	CapacityEvictionsVar         *expvar.Map
+	CapacityRejectionsVar        *expvar.Map
 }
 
 func (m *ExpVarMetrics) String() (out string) {
@@ -57,12 +116,24 @@ func (m *ExpVarMetrics) String() (out string) {
 	m.WriteTxnDurationVar.Do(func(kv expvar.KeyValue) {
 		fmt.Fprintf(&b, "write_txn_duration[%s]: %s\n", kv.Key, kv.Value.String())
 	})
+	m.WriteTxnLongHeldVar.Do(func(kv expvar.KeyValue) {
+		fmt.Fprintf(&b, "write_txn_long_held[%s]: %s\n", kv.Key, kv.Value.String())
+	})
 	m.DeleteTrackerCountVar.Do(func(kv expvar.KeyValue) {
 		fmt.Fprintf(&b, "delete_tracker_count[%s]: %s\n", kv.Key, kv.Value.String())
 	})
 	m.RevisionVar.Do(func(kv expvar.KeyValue) {
 		fmt.Fprintf(&b, "revision[%s]: %s\n", kv.Key, kv.Value.String())
 	})
+	m.HistoryRetainedVar.Do(func(kv expvar.KeyValue) {
+		fmt.Fprintf(&b, "history_retained[%s]: %s\n", kv.Key, kv.Value.String())
+	})
+	m.CapacityEvictionsVar.Do(func(kv expvar.KeyValue) {
+		fmt.Fprintf(&b, "capacity_evictions[%s]: %s\n", kv.Key, kv.Value.String())
+	})
+	m.CapacityRejectionsVar.Do(func(kv expvar.KeyValue) {
+		fmt.Fprintf(&b, "capacity_rejections[%s]: %s\n", kv.Key, kv.Value.String())
+	})
 
 	return b.String()
 }
@@ -82,8 +153,12 @@ func NewExpVarMetrics(publish bool) *ExpVarMetrics {
 		ObjectCountVar:               newMap("object_count"),
 		WriteTxnAcquisitionVar:       newMap("write_txn_acquisition"),
 		WriteTxnDurationVar:          newMap("write_txn_duration"),
+		WriteTxnLongHeldVar:          newMap("write_txn_long_held"),
 		DeleteTrackerCountVar:        newMap("delete_tracker_count"),
 		RevisionVar:                  newMap("revision"),
+		HistoryRetainedVar:           newMap("history_retained"),
+		CapacityEvictionsVar:         newMap("capacity_evictions"),
+		CapacityRejectionsVar:        newMap("capacity_rejections"),
 	}
 }
 
@@ -99,6 +174,22 @@ func (m *ExpVarMetrics) Revision(name string, revision uint64) {
 	m.RevisionVar.Set(name, &intVar)
 }
 
+func (m *ExpVarMetrics) HistoryRetained(numCommits int, numChanges int) {
+	var commitsVar, changesVar expvar.Int
+	commitsVar.Set(int64(numCommits))
+	changesVar.Set(int64(numChanges))
+	m.HistoryRetainedVar.Set("commits", &commitsVar)
+	m.HistoryRetainedVar.Set("changes", &changesVar)
+}
+
+func (m *ExpVarMetrics) CapacityEviction(name string) {
+	m.CapacityEvictionsVar.Add(name, 1)
+}
+
+func (m *ExpVarMetrics) CapacityRejection(name string) {
+	m.CapacityRejectionsVar.Add(name, 1)
+}
+
 func (m *ExpVarMetrics) GraveyardCleaningDuration(name string, duration time.Duration) {
 	m.GraveyardCleaningDurationVar.AddFloat(name, duration.Seconds())
 }
@@ -125,6 +216,10 @@ func (m *ExpVarMetrics) WriteTxnDuration(handle string, tables []string, acquire
 	m.WriteTxnDurationVar.AddFloat(handle+"/"+strings.Join(tables, "+"), acquire.Seconds())
 }
 
+func (m *ExpVarMetrics) WriteTxnLongHeld(handle string, tables []string, held time.Duration) {
+	m.WriteTxnLongHeldVar.Add(handle+"/"+strings.Join(tables, "+"), 1)
+}
+
 func (m *ExpVarMetrics) WriteTxnTotalAcquisition(handle string, tables []string, acquire time.Duration) {
 	m.WriteTxnAcquisitionVar.AddFloat(handle+"/"+strings.Join(tables, "+"), acquire.Seconds())
 }
@@ -133,10 +228,23 @@ func (m *ExpVarMetrics) WriteTxnTableAcquisition(handle string, tableName string
 	m.LockContentionVar.AddFloat(handle+"/"+tableName, acquire.Seconds())
 }
 
-var _ Metrics = &ExpVarMetrics{}
+var (
+	_ Metrics             = &ExpVarMetrics{}
+	_ HistoryMetrics      = &ExpVarMetrics{}
+	_ LockWatchdogMetrics = &ExpVarMetrics{}
+	_ CapacityMetrics     = &ExpVarMetrics{}
+)
 
 type NopMetrics struct{}
 
+// CapacityEviction implements CapacityMetrics.
+func (*NopMetrics) CapacityEviction(tableName string) {
+}
+
+// CapacityRejection implements CapacityMetrics.
+func (*NopMetrics) CapacityRejection(tableName string) {
+}
+
 // DeleteTrackerCount implements Metrics.
 func (*NopMetrics) DeleteTrackerCount(tableName string, numTrackers int) {
 }
@@ -157,6 +265,10 @@ func (*NopMetrics) GraveyardObjectCount(tableName string, numDeletedObjects int)
 func (*NopMetrics) ObjectCount(tableName string, numObjects int) {
 }
 
+// HistoryRetained implements HistoryMetrics.
+func (*NopMetrics) HistoryRetained(numCommits int, numChanges int) {
+}
+
 // Revision implements Metrics.
 func (*NopMetrics) Revision(tableName string, revision uint64) {
 }
@@ -165,6 +277,10 @@ func (*NopMetrics) Revision(tableName string, revision uint64) {
 func (*NopMetrics) WriteTxnDuration(handle string, tables []string, acquire time.Duration) {
 }
 
+// WriteTxnLongHeld implements LockWatchdogMetrics.
+func (*NopMetrics) WriteTxnLongHeld(handle string, tables []string, held time.Duration) {
+}
+
 // WriteTxnTableAcquisition implements Metrics.
 func (*NopMetrics) WriteTxnTableAcquisition(handle string, tableName string, acquire time.Duration) {
 }
@@ -173,4 +289,9 @@ func (*NopMetrics) WriteTxnTableAcquisition(handle string, tableName string, acq
 func (*NopMetrics) WriteTxnTotalAcquisition(handle string, tables []string, acquire time.Duration) {
 }
 
-var _ Metrics = &NopMetrics{}
+var (
+	_ Metrics             = &NopMetrics{}
+	_ HistoryMetrics      = &NopMetrics{}
+	_ LockWatchdogMetrics = &NopMetrics{}
+	_ CapacityMetrics     = &NopMetrics{}
+)
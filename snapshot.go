@@ -0,0 +1,323 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package statedb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	iradix "github.com/hashicorp/go-immutable-radix/v2"
+
+	"github.com/cilium/statedb/index"
+)
+
+const (
+	snapshotMagic   = "statedb-snapshot"
+	snapshotVersion = 1
+
+	// maxRecordSize is the maximum length of a byte slice read from a
+	// snapshot or of a journal record. Larger lengths are rejected as corrupt.
+	maxRecordSize = 1 << 30
+)
+
+// Snapshot writes the contents of all tables in the database into the given
+// writer. The snapshot is taken from a read transaction and is thus consistent
+// across all tables. The objects are encoded with the table's codec (see
+// WithCodec) and are stored together with their revisions. Deleted objects
+// that have not yet been garbage collected from the graveyard are included.
+//
+// The snapshot has the format:
+//
+//	snapshot := magic version numTables table*
+//	table    := name revision numObjects object* numDeleted object*
+//	object   := revision data
+//
+// Integers are encoded as unsigned varints and strings and byte slices are
+// prefixed by their length.
+func (db *DB) Snapshot(w io.Writer) error {
+	return db.ReadTxn().getTxn().writeSnapshot(w)
+}
+
+// Restore replaces the contents of the tables found in the snapshot written
+// by Snapshot. The tables in the snapshot must be registered to the database,
+// but tables not found in the snapshot are left untouched.
+//
+// The objects retain the revisions they had when the snapshot was taken and
+// the table revision will not go backwards. This allows delete trackers to
+// resume from the revision they had processed prior to the snapshot being
+// taken by marking it with (*DeleteTracker[Obj]).Mark.
+//
+// Restore is meant to be called on startup before the tables are in use.
+func (db *DB) Restore(r io.Reader) error {
+	rr := newRecordReader(r, ErrInvalidSnapshot)
+	if err := rr.readSnapshotHeader(); err != nil {
+		return err
+	}
+	numTables, err := rr.readUvarint()
+	if err != nil {
+		return err
+	}
+	if numTables == 0 {
+		return nil
+	}
+
+	root := db.state.Load().root
+	tables := root.tables()
+	if len(tables) == 0 {
+		name, err := rr.readString()
+		if err != nil {
+			return err
+		}
+		return tableError(name, ErrTableNotRegistered)
+	}
+
+	// Lock all the tables as we don't know beforehand which tables
+	// the snapshot contains.
+	txn := db.WriteTxn(tables[0], tables[1:]...).getTxn()
+	defer txn.Abort()
+
+	for i := uint64(0); i < numTables; i++ {
+		name, err := rr.readString()
+		if err != nil {
+			return err
+		}
+		meta := root.tableMeta(name)
+		if meta == nil {
+			return tableError(name, ErrTableNotRegistered)
+		}
+		if err := txn.restoreTable(meta, rr); err != nil {
+			return tableError(name, err)
+		}
+	}
+	return txn.Commit()
+}
+
+func (txn *txn) writeSnapshot(w io.Writer) error {
+	rw := newRecordWriter(w)
+	rw.writeString(snapshotMagic)
+	rw.writeUvarint(snapshotVersion)
+	rw.writeUvarint(uint64(len(txn.root.tables())))
+	for _, table := range txn.root {
+		if table.meta == nil {
+			continue
+		}
+		rw.writeString(table.meta.Name())
+		rw.writeUvarint(table.revision)
+		for _, pos := range []int{PrimaryIndexPos, GraveyardIndexPos} {
+			tree := table.indexes[pos].tree
+			rw.writeUvarint(uint64(tree.Len()))
+			if err := rw.writeObjects(table.meta, tree); err != nil {
+				return tableError(table.meta.Name(), err)
+			}
+		}
+	}
+	return rw.flush()
+}
+
+// restoreTable replaces the contents of the table with the objects read
+// from the snapshot.
+func (txn *txn) restoreTable(meta TableMeta, rr *recordReader) error {
+	table := txn.modifiedTables[meta.tablePos()]
+	revision, err := rr.readUvarint()
+	if err != nil {
+		return err
+	}
+
+	// Clear out the indexes. This is done via the index transactions in
+	// order to close the watch channels of the removed objects on commit.
+	for pos := range table.indexes {
+		txn.mustIndexWriteTxn(meta, pos).DeletePrefix(nil)
+	}
+	table.objectCount = 0
+
+	numObjects, err := rr.readUvarint()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < numObjects; i++ {
+		obj, err := rr.readObject(meta)
+		if err != nil {
+			return err
+		}
+		txn.restoreObject(meta, obj)
+	}
+
+	numDeleted, err := rr.readUvarint()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < numDeleted; i++ {
+		obj, err := rr.readObject(meta)
+		if err != nil {
+			return err
+		}
+		idKey := meta.primary().fromObject(obj).First()
+		txn.mustIndexWriteTxn(meta, GraveyardIndexPos).Insert(idKey, obj)
+		txn.mustIndexWriteTxn(meta, GraveyardRevisionIndexPos).Insert(index.Uint64(obj.revision), obj)
+	}
+
+	if revision > table.revision {
+		table.revision = revision
+	}
+	return nil
+}
+
+// restoreObject inserts the object into the primary, revision and secondary
+// indexes retaining its revision. The indexes are assumed to not contain the
+// object.
+func (txn *txn) restoreObject(meta TableMeta, obj object) {
+	idKey := meta.primary().fromObject(obj).First()
+	txn.mustIndexWriteTxn(meta, PrimaryIndexPos).Insert(idKey, obj)
+	txn.modifiedTables[meta.tablePos()].objectCount++
+	txn.mustIndexWriteTxn(meta, RevisionIndexPos).Insert(index.Uint64(obj.revision), obj)
+	for _, indexer := range txn.modifiedTables[meta.tablePos()].secondary {
+		txn.indexObject(meta, indexer, idKey, obj)
+	}
+}
+
+// recordWriter writes varint-encoded integers and length-prefixed byte
+// slices. Write errors are sticky and returned by flush().
+type recordWriter struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+}
+
+func newRecordWriter(w io.Writer) *recordWriter {
+	return &recordWriter{w: bufio.NewWriter(w)}
+}
+
+func (rw *recordWriter) writeUvarint(n uint64) {
+	rw.w.Write(binary.AppendUvarint(rw.buf[:0], n))
+}
+
+func (rw *recordWriter) writeBytes(b []byte) {
+	rw.writeUvarint(uint64(len(b)))
+	rw.w.Write(b)
+}
+
+func (rw *recordWriter) writeString(s string) {
+	rw.writeUvarint(uint64(len(s)))
+	rw.w.WriteString(s)
+}
+
+func (rw *recordWriter) writeObject(meta TableMeta, obj object) error {
+	data, err := meta.encodeObject(obj.data)
+	if err != nil {
+		return err
+	}
+	rw.writeUvarint(obj.revision)
+	rw.writeBytes(data)
+	return nil
+}
+
+func (rw *recordWriter) writeObjects(meta TableMeta, tree *iradix.Tree[object]) error {
+	iter := tree.Root().Iterator()
+	for _, obj, ok := iter.Next(); ok; _, obj, ok = iter.Next() {
+		if err := rw.writeObject(meta, obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rw *recordWriter) flush() error {
+	return rw.w.Flush()
+}
+
+// recordReader reads what recordWriter wrote.
+type recordReader struct {
+	r *bufio.Reader
+
+	// corrupt is the error wrapped when the input is found to be corrupt.
+	corrupt error
+}
+
+func newRecordReader(r io.Reader, corrupt error) *recordReader {
+	return &recordReader{bufio.NewReader(r), corrupt}
+}
+
+func (rr *recordReader) readUvarint() (uint64, error) {
+	n, err := binary.ReadUvarint(rr.r)
+	if err != nil {
+		return 0, unexpectedEOF(err)
+	}
+	return n, nil
+}
+
+func (rr *recordReader) readBytes() ([]byte, error) {
+	n, err := rr.readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	if n > maxRecordSize {
+		return nil, fmt.Errorf("%w: length %d exceeds the maximum record size", rr.corrupt, n)
+	}
+	// Grow the buffer as the data is read rather than allocating based on
+	// the length, which may be corrupt.
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, rr.r, int64(n)); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (rr *recordReader) readString() (string, error) {
+	b, err := rr.readBytes()
+	return string(b), err
+}
+
+func (rr *recordReader) readObject(meta TableMeta) (object, error) {
+	revision, err := rr.readUvarint()
+	if err != nil {
+		return object{}, err
+	}
+	data, err := rr.readBytes()
+	if err != nil {
+		return object{}, err
+	}
+	obj, err := meta.decodeObject(data)
+	if err != nil {
+		return object{}, err
+	}
+	return object{revision: revision, data: obj}, nil
+}
+
+func (rr *recordReader) readSnapshotHeader() error {
+	// Check the length of the magic before reading it in to not allocate
+	// based on the length read from arbitrary input.
+	n, err := rr.readUvarint()
+	if err != nil {
+		return err
+	}
+	magic := make([]byte, len(snapshotMagic))
+	if n == uint64(len(magic)) {
+		_, err = io.ReadFull(rr.r, magic)
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+	}
+	if !bytes.Equal(magic, []byte(snapshotMagic)) {
+		return fmt.Errorf("%w: bad magic", ErrInvalidSnapshot)
+	}
+	version, err := rr.readUvarint()
+	if err != nil {
+		return err
+	}
+	if version != snapshotVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidSnapshot, version)
+	}
+	return nil
+}
+
+// unexpectedEOF converts io.EOF into io.ErrUnexpectedEOF as the reader never
+// expects to hit the end in the middle of a snapshot.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
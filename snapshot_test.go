@@ -0,0 +1,142 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package statedb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_SnapshotRestore(t *testing.T) {
+	t.Parallel()
+
+	db, table, _ := newTestDB(t, tagsIndex)
+
+	wtxn := db.WriteTxn(table)
+	dt, err := table.DeleteTracker(wtxn, "test")
+	require.NoError(t, err)
+	for i := 1; i <= 10; i++ {
+		_, _, err := table.Insert(wtxn, testObject{ID: uint64(i), Tags: []string{"foo"}})
+		require.NoError(t, err)
+	}
+	_, _, err = table.Delete(wtxn, testObject{ID: 5})
+	require.NoError(t, err)
+	wtxn.Commit()
+	defer dt.Close()
+
+	var buf bytes.Buffer
+	require.NoError(t, db.Snapshot(&buf))
+
+	// Restore into a fresh database.
+	db2, table2, _ := newTestDB(t, tagsIndex)
+	require.NoError(t, db2.Restore(bytes.NewReader(buf.Bytes())))
+
+	txn, txn2 := db.ReadTxn(), db2.ReadTxn()
+	require.Equal(t, table.Revision(txn), table2.Revision(txn2))
+	require.Equal(t, 9, table2.NumObjects(txn2))
+
+	iter, _ := table.All(txn)
+	for obj, rev, ok := iter.Next(); ok; obj, rev, ok = iter.Next() {
+		obj2, rev2, found := table2.First(txn2, idIndex.Query(obj.ID))
+		require.True(t, found, "object %d not restored", obj.ID)
+		require.Equal(t, obj, obj2)
+		require.Equal(t, rev, rev2)
+	}
+
+	iter, _ = table2.Get(txn2, tagsIndex.Query("foo"))
+	require.Len(t, Collect(iter), 9)
+
+	// The deleted object is restored into the graveyard.
+	require.False(t, db2.graveyardIsEmpty())
+
+	// Restoring again replaces the contents.
+	wtxn = db2.WriteTxn(table2)
+	table2.Insert(wtxn, testObject{ID: 100})
+	wtxn.Commit()
+	require.NoError(t, db2.Restore(bytes.NewReader(buf.Bytes())))
+	_, _, found := table2.First(db2.ReadTxn(), idIndex.Query(100))
+	require.False(t, found)
+	require.Equal(t, 9, table2.NumObjects(db2.ReadTxn()))
+}
+
+func TestDB_Restore_Invalid(t *testing.T) {
+	t.Parallel()
+
+	db, table, _ := newTestDB(t)
+
+	err := db.Restore(bytes.NewReader([]byte("garbage")))
+	require.ErrorIs(t, err, ErrInvalidSnapshot)
+
+	var buf bytes.Buffer
+	require.NoError(t, db.Snapshot(&buf))
+
+	err = db.Restore(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	require.Error(t, err)
+
+	// Corrupt lengths are rejected without allocating based on them.
+	var corrupt bytes.Buffer
+	rw := newRecordWriter(&corrupt)
+	rw.writeString(snapshotMagic)
+	rw.writeUvarint(snapshotVersion)
+	rw.writeUvarint(1)
+	rw.writeUvarint(math.MaxUint64)
+	require.NoError(t, rw.flush())
+	err = db.Restore(bytes.NewReader(corrupt.Bytes()))
+	require.ErrorIs(t, err, ErrInvalidSnapshot)
+
+	corrupt.Truncate(corrupt.Len() - binary.MaxVarintLen64)
+	rw.writeUvarint(maxRecordSize)
+	require.NoError(t, rw.flush())
+	err = db.Restore(bytes.NewReader(corrupt.Bytes()))
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Restoring into a database without the table fails.
+	db2, _ := NewDB(nil, NewExpVarMetrics(false))
+	err = db2.Restore(bytes.NewReader(buf.Bytes()))
+	require.ErrorIs(t, err, ErrTableNotRegistered)
+
+	require.Equal(t, 0, table.NumObjects(db.ReadTxn()))
+}
+
+func TestDB_Restore_Graveyard(t *testing.T) {
+	t.Parallel()
+
+	db, table, _ := newTestDB(t)
+
+	wtxn := db.WriteTxn(table)
+	dt, err := table.DeleteTracker(wtxn, "test")
+	require.NoError(t, err)
+	table.Insert(wtxn, testObject{ID: 1})
+	table.Delete(wtxn, testObject{ID: 1})
+	wtxn.Commit()
+	defer dt.Close()
+
+	var buf bytes.Buffer
+	require.NoError(t, db.Snapshot(&buf))
+
+	db2, table2, _ := newTestDB(t)
+	require.NoError(t, db2.Restore(bytes.NewReader(buf.Bytes())))
+
+	// Re-inserting the deleted object without delete trackers removes it
+	// from the restored graveyard and thus deleting it again with a delete
+	// tracker does not end up with a double deletion.
+	wtxn = db2.WriteTxn(table2)
+	table2.Insert(wtxn, testObject{ID: 1})
+	wtxn.Commit()
+	require.True(t, db2.graveyardIsEmpty())
+
+	wtxn = db2.WriteTxn(table2)
+	dt2, err := table2.DeleteTracker(wtxn, "test")
+	require.NoError(t, err)
+	_, hadOld, err := table2.Delete(wtxn, testObject{ID: 1})
+	require.NoError(t, err)
+	require.True(t, hadOld)
+	wtxn.Commit()
+	dt2.Close()
+}
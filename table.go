@@ -4,9 +4,11 @@
 package statedb
 
 import (
+	"bytes"
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/cilium/statedb/internal"
 	iradix "github.com/hashicorp/go-immutable-radix/v2"
@@ -30,23 +32,35 @@ func NewTable[Obj any](
 	primaryIndexer Indexer[Obj],
 	secondaryIndexers ...Indexer[Obj],
 ) (RWTable[Obj], error) {
-	toAnyIndexer := func(idx Indexer[Obj]) anyIndexer {
-		return anyIndexer{
-			name: idx.indexName(),
-			fromObject: func(iobj object) index.KeySet {
-				return idx.fromObject(iobj.data.(Obj))
-			},
-			unique: idx.isUnique(),
-		}
-	}
+	return NewTableWithOptions[Obj](tableName, primaryIndexer, secondaryIndexers)
+}
 
+// NewTableWithOptions creates a new table with given name, indexes and
+// options. See NewTable.
+//
+//	statedb.NewTableWithOptions[*MyObject](
+//		"my-objects",
+//		MyObjectIDIndex,
+//		[]statedb.Indexer[*MyObject]{MyObjectNameIndex},
+//		statedb.WithCodec[*MyObject](myObjectCodec{}),
+//	)
+func NewTableWithOptions[Obj any](
+	tableName TableName,
+	primaryIndexer Indexer[Obj],
+	secondaryIndexers []Indexer[Obj],
+	opts ...TableOption[Obj],
+) (RWTable[Obj], error) {
 	table := &genTable[Obj]{
 		table:                tableName,
 		smu:                  internal.NewSortableMutex(),
-		primaryAnyIndexer:    toAnyIndexer(primaryIndexer),
+		primaryAnyIndexer:    toAnyIndexer[Obj](primaryIndexer),
 		primaryIndexer:       primaryIndexer,
 		secondaryAnyIndexers: make(map[string]anyIndexer, len(secondaryIndexers)),
 		indexPositions:       make(map[string]int),
+		codec:                JSONCodec[Obj]{},
+	}
+	for _, opt := range opts {
+		opt(table)
 	}
 
 	table.indexPositions[primaryIndexer.indexName()] = PrimaryIndexPos
@@ -59,12 +73,18 @@ func NewTable[Obj any](
 	indexPos := SecondaryIndexStartPos
 	for _, indexer := range secondaryIndexers {
 		name := indexer.indexName()
-		anyIndexer := toAnyIndexer(indexer)
+		anyIndexer := toAnyIndexer[Obj](indexer)
 		anyIndexer.pos = indexPos
 		table.secondaryAnyIndexers[name] = anyIndexer
 		table.indexPositions[name] = indexPos
 		indexPos++
 	}
+	if table.expiresAt != nil {
+		anyIndexer := toAnyIndexer[Obj](table.expiryIndex())
+		anyIndexer.pos = indexPos
+		table.secondaryAnyIndexers[ExpiryIndex] = anyIndexer
+		table.indexPositions[ExpiryIndex] = indexPos
+	}
 
 	// Primary index must always be unique
 	if !primaryIndexer.isUnique() {
@@ -88,6 +108,16 @@ func NewTable[Obj any](
 	return table, nil
 }
 
+func toAnyIndexer[Obj any](idx Indexer[Obj]) anyIndexer {
+	return anyIndexer{
+		name: idx.indexName(),
+		fromObject: func(iobj object) index.KeySet {
+			return idx.fromObject(iobj.data.(Obj))
+		},
+		unique: idx.isUnique(),
+	}
+}
+
 // MustNewTable creates a new table with given name and indexes.
 // Panics if indexes are malformed.
 func MustNewTable[Obj any](
@@ -101,6 +131,67 @@ func MustNewTable[Obj any](
 	return t
 }
 
+// TableOption configures optional behaviour of a table. See
+// NewTableWithOptions.
+type TableOption[Obj any] func(*genTable[Obj])
+
+// WithCodec sets the codec used to encode and decode the table's objects
+// when the database is persisted with DB.Snapshot and DB.Restore. By default
+// objects are encoded as JSON.
+func WithCodec[Obj any](codec Codec[Obj]) TableOption[Obj] {
+	return func(t *genTable[Obj]) {
+		t.codec = codec
+	}
+}
+
+// WithValidator adds a function to validate the objects inserted into the
+// table with Insert and CompareAndSwap. If the function returns an error the
+// object is rejected with ErrInvalidObject wrapping the error. Multiple
+// validators can be added and they are run in the order given.
+func WithValidator[Obj any](validate func(Obj) error) TableOption[Obj] {
+	return func(t *genTable[Obj]) {
+		t.validators = append(t.validators, validate)
+	}
+}
+
+// WithExpiry enables the expiry of the table's objects. The function returns
+// the time at which the object expires or the zero time if it does not expire.
+// Expired objects are deleted by a background worker once the database has
+// been started. The deletions are done in write transactions against the table
+// like any other deletions and are thus seen by the delete trackers.
+func WithExpiry[Obj any](expiresAt func(Obj) time.Time) TableOption[Obj] {
+	return func(t *genTable[Obj]) {
+		t.expiresAt = expiresAt
+	}
+}
+
+// WithCapacity limits the number of objects in the table to maxObjects.
+// Inserting a new object into a full table evicts an object as specified by
+// the policy or fails with ErrTableFull. The evicted objects are deleted like
+// with Delete and are thus seen by the delete trackers.
+func WithCapacity[Obj any](maxObjects int, policy EvictionPolicy) TableOption[Obj] {
+	return func(t *genTable[Obj]) {
+		t.maxObjects = maxObjects
+		t.evictionPolicy = policy
+	}
+}
+
+// WithEqual sets the function for comparing objects. If an inserted object is
+// equal to the existing object with the same primary key, the insert is
+// skipped: the object keeps its revision and the watch channels are not closed.
+func WithEqual[Obj any](equal func(a, b Obj) bool) TableOption[Obj] {
+	return func(t *genTable[Obj]) {
+		t.equal = func(a, b any) bool {
+			return equal(a.(Obj), b.(Obj))
+		}
+	}
+}
+
+// WithEqualMethod is WithEqual using the Equal method of the objects.
+func WithEqualMethod[Obj interface{ Equal(Obj) bool }]() TableOption[Obj] {
+	return WithEqual(func(a, b Obj) bool { return a.Equal(b) })
+}
+
 type genTable[Obj any] struct {
 	pos                  int
 	table                TableName
@@ -109,6 +200,29 @@ type genTable[Obj any] struct {
 	primaryAnyIndexer    anyIndexer
 	secondaryAnyIndexers map[string]anyIndexer
 	indexPositions       map[string]int
+	codec                Codec[Obj]
+	validators           []func(Obj) error
+	expiresAt            func(Obj) time.Time
+	maxObjects           int
+	evictionPolicy       EvictionPolicy
+	equal                func(a, b any) bool
+}
+
+// expiryIndex indexes the objects by their expiry time. The objects that
+// do not expire are not indexed.
+func (t *genTable[Obj]) expiryIndex() Index[Obj, time.Time] {
+	return Index[Obj, time.Time]{
+		Name: ExpiryIndex,
+		FromObject: func(obj Obj) index.KeySet {
+			expiresAt := t.expiresAt(obj)
+			if expiresAt.IsZero() {
+				return index.NewKeySet()
+			}
+			return index.NewKeySet(expiryKey(expiresAt))
+		},
+		FromKey: expiryKey,
+		Unique:  false,
+	}
 }
 
 func (t *genTable[Obj]) tableEntry() tableEntry {
@@ -124,6 +238,7 @@ func (t *genTable[Obj]) tableEntry() tableEntry {
 	entry.indexes[t.indexPositions[RevisionIndex]] = indexEntry{iradix.New[object](), nil, true}
 	entry.indexes[t.indexPositions[GraveyardIndex]] = indexEntry{iradix.New[object](), nil, true}
 	entry.indexes[t.indexPositions[GraveyardRevisionIndex]] = indexEntry{iradix.New[object](), nil, true}
+	entry.secondary = t.secondaryAnyIndexers
 	return entry
 }
 
@@ -146,6 +261,14 @@ func (t *genTable[Obj]) indexPos(name string) int {
 	return t.indexPositions[name]
 }
 
+func (t *genTable[Obj]) capacity() (int, EvictionPolicy) {
+	return t.maxObjects, t.evictionPolicy
+}
+
+func (t *genTable[Obj]) equalFunc() func(a, b any) bool {
+	return t.equal
+}
+
 func (t *genTable[Obj]) PrimaryIndexer() Indexer[Obj] {
 	return t.primaryIndexer
 }
@@ -154,8 +277,12 @@ func (t *genTable[Obj]) primary() anyIndexer {
 	return t.primaryAnyIndexer
 }
 
-func (t *genTable[Obj]) secondary() map[string]anyIndexer {
-	return t.secondaryAnyIndexers
+func (t *genTable[Obj]) encodeObject(data any) ([]byte, error) {
+	return t.codec.Encode(data.(Obj))
+}
+
+func (t *genTable[Obj]) decodeObject(data []byte) (any, error) {
+	return t.codec.Decode(data)
 }
 
 func (t *genTable[Obj]) Name() string {
@@ -202,7 +329,7 @@ func (t *genTable[Obj]) First(txn ReadTxn, q Query[Obj]) (obj Obj, revision uint
 }
 
 func (t *genTable[Obj]) FirstWatch(txn ReadTxn, q Query[Obj]) (obj Obj, revision uint64, watch <-chan struct{}, ok bool) {
-	indexTxn := txn.getTxn().mustIndexReadTxn(t, t.indexPos(q.index))
+	indexTxn := txn.getTxn().mustIndexReadTxnByName(t, q.index)
 	var iobj object
 	if indexTxn.unique {
 		// On a unique index we can do a direct get rather than a prefix search.
@@ -245,7 +372,7 @@ func (t *genTable[Obj]) Last(txn ReadTxn, q Query[Obj]) (obj Obj, revision uint6
 }
 
 func (t *genTable[Obj]) LastWatch(txn ReadTxn, q Query[Obj]) (obj Obj, revision uint64, watch <-chan struct{}, ok bool) {
-	indexTxn := txn.getTxn().mustIndexReadTxn(t, t.indexPos(q.index))
+	indexTxn := txn.getTxn().mustIndexReadTxnByName(t, q.index)
 	var iobj object
 	if indexTxn.unique {
 		// On a unique index we can do a direct get rather than a prefix search.
@@ -282,7 +409,7 @@ func (t *genTable[Obj]) LastWatch(txn ReadTxn, q Query[Obj]) (obj Obj, revision
 }
 
 func (t *genTable[Obj]) LowerBound(txn ReadTxn, q Query[Obj]) (Iterator[Obj], <-chan struct{}) {
-	indexTxn := txn.getTxn().mustIndexReadTxn(t, t.indexPos(q.index))
+	indexTxn := txn.getTxn().mustIndexReadTxnByName(t, q.index)
 	root := indexTxn.Root()
 
 	// Since LowerBound query may be invalidated by changes in another branch
@@ -294,8 +421,73 @@ func (t *genTable[Obj]) LowerBound(txn ReadTxn, q Query[Obj]) (Iterator[Obj], <-
 	return &iterator[Obj]{iter}, watch
 }
 
+func (t *genTable[Obj]) UpperBound(txn ReadTxn, q Query[Obj]) (Iterator[Obj], <-chan struct{}) {
+	indexTxn := txn.getTxn().mustIndexReadTxnByName(t, q.index)
+	root := indexTxn.Root()
+
+	// As with LowerBound we watch the whole table for changes.
+	watch, _, _ := root.GetWatch(nil)
+	iter := root.ReverseIterator()
+	if indexTxn.unique {
+		iter.SeekReverseLowerBound(q.key)
+		return &iterator[Obj]{reverseIterator{iter}}, watch
+	}
+
+	// The non-unique keys are suffixed by the primary key and thus a key with
+	// a secondary key less than the query may sort after any key with the query
+	// as prefix. Iterate from the end and skip the keys with a greater secondary
+	// key.
+	return &upperBoundIterator[Obj]{reverseIterator{iter}, q.key}, watch
+}
+
+func (t *genTable[Obj]) Range(txn ReadTxn, from, to Query[Obj]) (Iterator[Obj], <-chan struct{}) {
+	if from.index != to.index {
+		panic(fmt.Sprintf("Range() with queries on different indexes %q and %q", from.index, to.index))
+	}
+	indexTxn := txn.getTxn().mustIndexReadTxnByName(t, from.index)
+	it := &rangeIterator[Obj]{
+		from:   from.key,
+		to:     to.key,
+		unique: indexTxn.unique,
+	}
+
+	// All keys in the range share the common prefix of the bounds.
+	watchPrefix := from.key[:commonPrefixLen(from.key, to.key)]
+	if !indexTxn.unique {
+		// The non-unique keys are suffixed by the primary key and thus an
+		// object with a secondary key that is a prefix of 'to' may have a
+		// key larger than 'to'. Find the shortest such prefix that is
+		// within the range.
+		for i := range to.key {
+			if bytes.Compare(to.key[:i], from.key) >= 0 {
+				it.tail, it.hasTail = to.key[:i], true
+				if len(it.tail) < len(watchPrefix) {
+					watchPrefix = it.tail
+				}
+				break
+			}
+		}
+	}
+
+	root := indexTxn.Root()
+	watch := root.Iterator().SeekPrefixWatch(watchPrefix)
+	iter := root.Iterator()
+	iter.SeekLowerBound(from.key)
+	it.iter = iter
+	return it, watch
+}
+
+// commonPrefixLen returns the length of the common prefix of the keys.
+func commonPrefixLen(a, b []byte) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
 func (t *genTable[Obj]) Prefix(txn ReadTxn, q Query[Obj]) (Iterator[Obj], <-chan struct{}) {
-	indexTxn := txn.getTxn().mustIndexReadTxn(t, t.indexPos(q.index))
+	indexTxn := txn.getTxn().mustIndexReadTxnByName(t, q.index)
 	root := indexTxn.Root()
 	iter := root.Iterator()
 	watch := iter.SeekPrefixWatch(q.key)
@@ -311,7 +503,7 @@ func (t *genTable[Obj]) All(txn ReadTxn) (Iterator[Obj], <-chan struct{}) {
 }
 
 func (t *genTable[Obj]) Get(txn ReadTxn, q Query[Obj]) (Iterator[Obj], <-chan struct{}) {
-	indexTxn := txn.getTxn().mustIndexReadTxn(t, t.indexPos(q.index))
+	indexTxn := txn.getTxn().mustIndexReadTxnByName(t, q.index)
 	iter := indexTxn.Root().Iterator()
 	watchCh := iter.SeekPrefixWatch(q.key)
 
@@ -321,7 +513,35 @@ func (t *genTable[Obj]) Get(txn ReadTxn, q Query[Obj]) (Iterator[Obj], <-chan st
 	return &nonUniqueIterator[Obj]{iter, q.key}, watchCh
 }
 
+func (t *genTable[Obj]) PrefixReverse(txn ReadTxn, q Query[Obj]) (Iterator[Obj], <-chan struct{}) {
+	indexTxn := txn.getTxn().mustIndexReadTxnByName(t, q.index)
+	iter := indexTxn.Root().ReverseIterator()
+	watch := iter.SeekPrefixWatch(q.key)
+	return &iterator[Obj]{reverseIterator{iter}}, watch
+}
+
+func (t *genTable[Obj]) AllReverse(txn ReadTxn) (Iterator[Obj], <-chan struct{}) {
+	indexTxn := txn.getTxn().mustIndexReadTxn(t, PrimaryIndexPos)
+	root := indexTxn.Root()
+	watchCh, _, _ := root.GetWatch(nil)
+	return &iterator[Obj]{reverseIterator{root.ReverseIterator()}}, watchCh
+}
+
+func (t *genTable[Obj]) GetReverse(txn ReadTxn, q Query[Obj]) (Iterator[Obj], <-chan struct{}) {
+	indexTxn := txn.getTxn().mustIndexReadTxnByName(t, q.index)
+	iter := indexTxn.Root().ReverseIterator()
+	watchCh := iter.SeekPrefixWatch(q.key)
+
+	if indexTxn.unique {
+		return &uniqueIterator[Obj]{reverseIterator{iter}, q.key}, watchCh
+	}
+	return &nonUniqueIterator[Obj]{reverseIterator{iter}, q.key}, watchCh
+}
+
 func (t *genTable[Obj]) Insert(txn WriteTxn, obj Obj) (oldObj Obj, hadOld bool, err error) {
+	if err = t.validate(obj); err != nil {
+		return
+	}
 	var old object
 	old, hadOld, err = txn.getTxn().Insert(t, Revision(0), obj)
 	if hadOld {
@@ -330,7 +550,36 @@ func (t *genTable[Obj]) Insert(txn WriteTxn, obj Obj) (oldObj Obj, hadOld bool,
 	return
 }
 
+func (t *genTable[Obj]) Modify(txn WriteTxn, obj Obj, merge func(old, new Obj) Obj) (oldObj Obj, hadOld bool, err error) {
+	var old object
+	old, hadOld, err = txn.getTxn().Modify(t, obj, func(old any, hadOld bool) (any, error) {
+		merged := obj
+		if hadOld {
+			merged = merge(old.(Obj), obj)
+		}
+		return merged, t.validate(merged)
+	})
+	if hadOld {
+		oldObj = old.data.(Obj)
+	}
+	return
+}
+
+func (t *genTable[Obj]) InsertMany(txn WriteTxn, iter Iterator[Obj]) error {
+	var objs []any
+	for obj, _, ok := iter.Next(); ok; obj, _, ok = iter.Next() {
+		if err := t.validate(obj); err != nil {
+			return err
+		}
+		objs = append(objs, obj)
+	}
+	return txn.getTxn().InsertMany(t, objs)
+}
+
 func (t *genTable[Obj]) CompareAndSwap(txn WriteTxn, rev Revision, obj Obj) (oldObj Obj, hadOld bool, err error) {
+	if err = t.validate(obj); err != nil {
+		return
+	}
 	var old object
 	old, hadOld, err = txn.getTxn().Insert(t, rev, obj)
 	if hadOld {
@@ -339,6 +588,16 @@ func (t *genTable[Obj]) CompareAndSwap(txn WriteTxn, rev Revision, obj Obj) (old
 	return
 }
 
+// validate runs the validators of the table on the object.
+func (t *genTable[Obj]) validate(obj Obj) error {
+	for _, validate := range t.validators {
+		if err := validate(obj); err != nil {
+			return tableError(t.table, fmt.Errorf("%w: %w", ErrInvalidObject, err))
+		}
+	}
+	return nil
+}
+
 func (t *genTable[Obj]) Delete(txn WriteTxn, obj Obj) (oldObj Obj, hadOld bool, err error) {
 	var old object
 	old, hadOld, err = txn.getTxn().Delete(t, Revision(0), obj)
@@ -357,6 +616,41 @@ func (t *genTable[Obj]) CompareAndDelete(txn WriteTxn, rev Revision, obj Obj) (o
 	return
 }
 
+func (t *genTable[Obj]) DeleteWhere(txn WriteTxn, q Query[Obj]) (numDeleted int, err error) {
+	itxn := txn.getTxn()
+	if itxn.db == nil {
+		return 0, ErrTransactionClosed
+	}
+	if itxn.modifiedTables[t.pos] == nil {
+		return 0, tableError(t.table, ErrTableNotLockedForWriting)
+	}
+
+	// The index is read from a clone of the index transaction and thus the
+	// deletions do not invalidate the iterator.
+	indexTxn, err := itxn.indexReadTxnByName(t, q.index)
+	if err != nil {
+		return 0, err
+	}
+	iter := indexTxn.Root().Iterator()
+	iter.SeekPrefix(q.key)
+	var objs Iterator[Obj]
+	if indexTxn.unique {
+		objs = &uniqueIterator[Obj]{iter, q.key}
+	} else {
+		objs = &nonUniqueIterator[Obj]{iter, q.key}
+	}
+	for obj, _, ok := objs.Next(); ok; obj, _, ok = objs.Next() {
+		_, hadOld, err := itxn.Delete(t, Revision(0), obj)
+		if err != nil {
+			return numDeleted, err
+		}
+		if hadOld {
+			numDeleted++
+		}
+	}
+	return numDeleted, nil
+}
+
 func (t *genTable[Obj]) DeleteAll(txn WriteTxn) error {
 	iter, _ := t.All(txn)
 	itxn := txn.getTxn()
@@ -369,6 +663,25 @@ func (t *genTable[Obj]) DeleteAll(txn WriteTxn) error {
 	return nil
 }
 
+func (t *genTable[Obj]) AddIndex(txn WriteTxn, indexer Indexer[Obj]) error {
+	return txn.getTxn().AddIndex(t, toAnyIndexer[Obj](indexer))
+}
+
+func (t *genTable[Obj]) DropIndex(txn WriteTxn, name IndexName) error {
+	return txn.getTxn().DropIndex(t, name)
+}
+
+func (t *genTable[Obj]) Changes(txn WriteTxn) Iterator[Change[Obj]] {
+	// The changes are not recorded unless needed on commit. Instead find them
+	// by comparing the table against its state at the start of the transaction.
+	itxn := txn.getTxn()
+	if itxn.db == nil {
+		return &sliceIterator[Change[Obj]]{}
+	}
+	before := newReadTxn(itxn.db, &dbState{root: itxn.root, commitID: itxn.commitID})
+	return diff[Obj](t, before, itxn, true)
+}
+
 func (t *genTable[Obj]) DeleteTracker(txn WriteTxn, trackerName string) (*DeleteTracker[Obj], error) {
 	dt := &DeleteTracker[Obj]{
 		db:          txn.getTxn().db,
@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package statedb
+
+import (
+	"github.com/cilium/statedb/index"
+)
+
+// TupleIndex is an index on two fields of an object, e.g. the namespace and
+// the name. The keys of the fields are combined with index.Tuple and thus the
+// objects are ordered by the first field and then by the second, and the
+// objects with a specific first field can be queried with QueryPrefix.
+// For more fields or multiple keys per object use Index with index.Tuple.
+//
+//	var nameIndex = statedb.TupleIndex[*Pod, string, string]{
+//	  Name: "name",
+//	  FromObject: func(p *Pod) (string, string) { return p.Namespace, p.Name },
+//	  FromFirst: index.String,
+//	  FromSecond: index.String,
+//	  Unique: true,
+//	}
+//
+//	pod, _, found := pods.First(txn, nameIndex.Query("default", "nginx"))
+//	iter, _ := pods.Prefix(txn, nameIndex.QueryPrefix("default"))
+type TupleIndex[Obj, A, B any] struct {
+	Name       string
+	FromObject func(obj Obj) (A, B)
+	FromFirst  func(a A) index.Key
+	FromSecond func(b B) index.Key
+	Unique     bool
+}
+
+var _ Indexer[struct{}] = &TupleIndex[struct{}, bool, bool]{}
+
+//nolint:unused
+func (i TupleIndex[Obj, A, B]) indexName() string {
+	return i.Name
+}
+
+//nolint:unused
+func (i TupleIndex[Obj, A, B]) fromObject(obj Obj) index.KeySet {
+	return index.NewKeySet(i.ObjectToKey(obj))
+}
+
+//nolint:unused
+func (i TupleIndex[Obj, A, B]) isUnique() bool {
+	return i.Unique
+}
+
+// Query constructs a query against this index from the keys of both fields.
+func (i TupleIndex[Obj, A, B]) Query(a A, b B) Query[Obj] {
+	return Query[Obj]{
+		index: i.Name,
+		key:   index.Tuple(i.FromFirst(a), i.FromSecond(b)),
+	}
+}
+
+// QueryPrefix constructs a query against this index from the key of the
+// first field. The query matches the objects with the first field equal to
+// the key when used with Prefix. It can also be used as a bound with
+// LowerBound and Range, but not with Get and First as they match the full key.
+func (i TupleIndex[Obj, A, B]) QueryPrefix(a A) Query[Obj] {
+	return Query[Obj]{
+		index: i.Name,
+		key:   index.Tuple(i.FromFirst(a)),
+	}
+}
+
+func (i TupleIndex[Obj, A, B]) QueryFromObject(obj Obj) Query[Obj] {
+	return Query[Obj]{
+		index: i.Name,
+		key:   i.ObjectToKey(obj),
+	}
+}
+
+func (i TupleIndex[Obj, A, B]) ObjectToKey(obj Obj) index.Key {
+	a, b := i.FromObject(obj)
+	return index.Tuple(i.FromFirst(a), i.FromSecond(b))
+}
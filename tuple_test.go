@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package statedb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cilium/statedb/index"
+)
+
+type namedObject struct {
+	Namespace, Name string
+	Port            uint16
+}
+
+var (
+	namedIndex = TupleIndex[namedObject, string, string]{
+		Name: "name",
+		FromObject: func(obj namedObject) (string, string) {
+			return obj.Namespace, obj.Name
+		},
+		FromFirst:  index.String,
+		FromSecond: index.String,
+		Unique:     true,
+	}
+
+	portIndex = TupleIndex[namedObject, uint16, string]{
+		Name: "port",
+		FromObject: func(obj namedObject) (uint16, string) {
+			return obj.Port, obj.Namespace
+		},
+		FromFirst:  index.Uint16,
+		FromSecond: index.String,
+	}
+)
+
+func TestTupleIndex(t *testing.T) {
+	t.Parallel()
+
+	table, err := NewTable("named", namedIndex, portIndex)
+	require.NoError(t, err)
+	db, err := NewDB([]TableMeta{table}, NewExpVarMetrics(false))
+	require.NoError(t, err)
+
+	wtxn := db.WriteTxn(table)
+	for _, obj := range []namedObject{
+		{Namespace: "a", Name: "bc", Port: 80},
+		{Namespace: "ab", Name: "c", Port: 80},
+		{Namespace: "a", Name: "b", Port: 443},
+		{Namespace: "b", Name: "a", Port: 80},
+	} {
+		_, _, err := table.Insert(wtxn, obj)
+		require.NoError(t, err)
+	}
+	wtxn.Commit()
+	txn := db.ReadTxn()
+
+	names := func(iter Iterator[namedObject]) (names []string) {
+		for obj, _, ok := iter.Next(); ok; obj, _, ok = iter.Next() {
+			names = append(names, obj.Namespace+"/"+obj.Name)
+		}
+		return
+	}
+
+	// The objects are ordered by namespace and then by name.
+	iter, _ := table.All(txn)
+	require.Equal(t, []string{"a/b", "a/bc", "ab/c", "b/a"}, names(iter))
+
+	obj, _, found := table.First(txn, namedIndex.Query("ab", "c"))
+	require.True(t, found)
+	require.Equal(t, uint16(80), obj.Port)
+	_, _, found = table.First(txn, namedIndex.Query("a", "bc"))
+	require.True(t, found)
+	_, _, found = table.First(txn, namedIndex.Query("a", "c"))
+	require.False(t, found)
+
+	// Prefix query on the leading field does not match the objects with
+	// a longer first field.
+	iter, _ = table.Prefix(txn, namedIndex.QueryPrefix("a"))
+	require.Equal(t, []string{"a/b", "a/bc"}, names(iter))
+	iter, _ = table.Range(txn, namedIndex.QueryPrefix("a"), namedIndex.QueryPrefix("b"))
+	require.Equal(t, []string{"a/b", "a/bc", "ab/c"}, names(iter))
+
+	// Non-unique tuple index.
+	iter, _ = table.Get(txn, portIndex.Query(80, "a"))
+	require.Equal(t, []string{"a/bc"}, names(iter))
+	iter, _ = table.Prefix(txn, portIndex.QueryPrefix(80))
+	require.Equal(t, []string{"a/bc", "ab/c", "b/a"}, names(iter))
+	iter, _ = table.Prefix(txn, portIndex.QueryPrefix(443))
+	require.Equal(t, []string{"a/b"}, names(iter))
+}
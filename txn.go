@@ -9,9 +9,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"maps"
 	"reflect"
 	"runtime"
 	"slices"
+	"strings"
 	"time"
 
 	iradix "github.com/hashicorp/go-immutable-radix/v2"
@@ -24,10 +26,41 @@ type txn struct {
 	db             *DB
 	handle         string
 	root           dbRoot
+	commitID       uint64                   // the commit ID of the root
 	modifiedTables []*tableEntry            // table entries being modified
 	smus           internal.SortableMutexes // the (sorted) table locks
 	acquiredAt     time.Time                // the time at which the transaction acquired the locks
+	watchdogID     uint64                   // the ID of the transaction in the lock watchdog
 	tableNames     []string
+	journal        *Journal      // the journal to append the changes to on commit if non-nil
+	foreignKeys    []*foreignKey // the foreign keys to check on commit
+	recordChanges  bool          // if true the changes are recorded for the journal or the foreign key checks
+	changes        []change      // the inserts and deletes performed in this transaction if 'recordChanges'
+	numChanges     int           // the number of inserts and deletes performed in this transaction
+	onCommit       []func(ReadTxn)
+	onAbort        []func()
+	savepoints     []savepointState
+	txnToNotify    []*iradix.Txn[object] // index transactions committed at savepoints
+}
+
+// change is an insert or a delete performed in a write transaction.
+type change struct {
+	meta    TableMeta
+	deleted bool
+	old     object // the replaced or deleted object if 'hadOld' is true
+	hadOld  bool
+	obj     object // the inserted object, or on deletion the deleted object with the revision of the deletion
+}
+
+// savepointState is the state of the write transaction at the time
+// Savepoint() was called.
+type savepointState struct {
+	tables      []*tableEntry // copies of the modified tables
+	numToNotify int
+	numChanges  int
+	numRecorded int
+	numOnCommit int
+	numOnAbort  int
 }
 
 // rooter is implemented by both iradix.Txn and iradix.Tree.
@@ -47,6 +80,15 @@ type indexTxn struct {
 
 var zeroTxn = txn{}
 
+// newReadTxn returns a read transaction against the given state.
+func newReadTxn(db *DB, state *dbState) *txn {
+	return &txn{
+		db:       db,
+		root:     state.root,
+		commitID: state.commitID,
+	}
+}
+
 // txn fulfills the ReadTxn/WriteTxn interface.
 func (txn *txn) getTxn() *txn {
 	return txn
@@ -57,6 +99,7 @@ func (txn *txn) getTxn() *txn {
 // Abort/Commit which would cause the table to be locked forever.
 func txnFinalizer(txn *txn) {
 	if txn.db != nil {
+		txn.db.watchdog.released(txn)
 		panic(fmt.Sprintf("WriteTxn from handle %s against tables %v was never Abort()'d or Commit()'d", txn.handle, txn.tableNames))
 	}
 }
@@ -86,6 +129,9 @@ func (txn *txn) indexReadTxn(meta TableMeta, indexPos int) (indexReadTxn, error)
 			return indexReadTxn{itxn.Txn.Clone(), itxn.unique}, nil
 		}
 	}
+	if !txn.root.isRegistered(meta) {
+		return indexReadTxn{}, tableError(meta.Name(), ErrTableNotRegistered)
+	}
 	indexEntry := txn.root[meta.tablePos()].indexes[indexPos]
 	return indexReadTxn{indexEntry.tree, indexEntry.unique}, nil
 }
@@ -100,7 +146,7 @@ func (txn *txn) indexWriteTxn(meta TableMeta, indexPos int) (indexTxn, error) {
 	indexEntry := &table.indexes[indexPos]
 	if indexEntry.txn == nil {
 		indexEntry.txn = indexEntry.tree.Txn()
-		indexEntry.txn.TrackMutate(true)
+		indexEntry.txn.TrackMutate(!txn.db.forked)
 	}
 	return indexTxn{indexEntry.txn, indexEntry.unique}, nil
 }
@@ -115,6 +161,35 @@ func (txn *txn) mustIndexReadTxn(meta TableMeta, indexPos int) indexReadTxn {
 	return indexTxn
 }
 
+// indexReadTxnByName returns a transaction to read from the named index.
+func (txn *txn) indexReadTxnByName(meta TableMeta, name IndexName) (indexReadTxn, error) {
+	var table *tableEntry
+	if txn.modifiedTables != nil {
+		table = txn.modifiedTables[meta.tablePos()]
+	}
+	if table == nil {
+		if !txn.root.isRegistered(meta) {
+			return indexReadTxn{}, tableError(meta.Name(), ErrTableNotRegistered)
+		}
+		table = &txn.root[meta.tablePos()]
+	}
+	indexPos, ok := table.indexPos(name)
+	if !ok {
+		return indexReadTxn{}, tableError(meta.Name(), fmt.Errorf("index %q: %w", name, ErrIndexNotFound))
+	}
+	return txn.indexReadTxn(meta, indexPos)
+}
+
+// mustIndexReadTxnByName returns a transaction to read from the named index.
+// Panics if table or index are not found.
+func (txn *txn) mustIndexReadTxnByName(meta TableMeta, name IndexName) indexReadTxn {
+	indexTxn, err := txn.indexReadTxnByName(meta, name)
+	if err != nil {
+		panic(err)
+	}
+	return indexTxn
+}
+
 // mustIndexReadTxn returns a transaction to read or write from the specific index.
 // Panics if table or index not found.
 func (txn *txn) mustIndexWriteTxn(meta TableMeta, indexPos int) indexTxn {
@@ -126,6 +201,17 @@ func (txn *txn) mustIndexWriteTxn(meta TableMeta, indexPos int) indexTxn {
 }
 
 func (txn *txn) Insert(meta TableMeta, guardRevision Revision, data any) (object, bool, error) {
+	return txn.modify(meta, guardRevision, data, nil)
+}
+
+// Modify inserts the object returned by merge, which is given the existing
+// object with the same primary key if there is one. The merged object must
+// have the same primary key.
+func (txn *txn) Modify(meta TableMeta, data any, merge func(old any, hadOld bool) (any, error)) (object, bool, error) {
+	return txn.modify(meta, Revision(0), data, merge)
+}
+
+func (txn *txn) modify(meta TableMeta, guardRevision Revision, data any, merge func(old any, hadOld bool) (any, error)) (object, bool, error) {
 	if txn.db == nil {
 		return object{}, false, ErrTransactionClosed
 	}
@@ -136,53 +222,115 @@ func (txn *txn) Insert(meta TableMeta, guardRevision Revision, data any) (object
 	if table == nil {
 		return object{}, false, tableError(tableName, ErrTableNotLockedForWriting)
 	}
+
+	obj := object{data: data}
+	idKey := meta.primary().fromObject(obj).First()
+
+	// Skip the update if the object is equal to the existing one. This
+	// requires a lookup prior to the insert as the insert would close the
+	// watch channels of the object. Modify() checks after the insert below
+	// to traverse the primary index only once.
+	equal := meta.equalFunc()
+	if equal != nil && merge == nil {
+		oldObj, oldExists := txn.mustIndexWriteTxn(meta, PrimaryIndexPos).Get(idKey)
+		if guardRevision > 0 {
+			switch {
+			case !oldExists:
+				return object{}, false, ErrObjectNotFound
+			case oldObj.revision != guardRevision:
+				return oldObj, true, ErrRevisionNotEqual
+			}
+		}
+		if oldExists {
+			checkNotSameObject(oldObj.data, data)
+			if equal(oldObj.data, data) {
+				return oldObj, true, nil
+			}
+		}
+	}
+
 	oldRevision := table.revision
 	table.revision++
 	revision := table.revision
+	obj.revision = revision
 
-	obj := object{
-		revision: revision,
-		data:     data,
-	}
-
-	// Update the primary index first
-	idKey := meta.primary().fromObject(obj).First()
+	// Update the primary index first. The insert returns the old object
+	// and thus only one lookup is needed in the common case. If the insert
+	// turns out to be invalid below it is reverted. We're assuming here that
+	// failures are rare.
 	idIndexTxn := txn.mustIndexWriteTxn(meta, PrimaryIndexPos)
 	oldObj, oldExists := idIndexTxn.Insert(idKey, obj)
-
-	// Sanity check: is the same object being inserted back and thus the
-	// immutable object is being mutated?
-	if oldExists {
-		val := reflect.ValueOf(data)
-		if val.Kind() == reflect.Pointer {
-			oldVal := reflect.ValueOf(oldObj.data)
-			if val.UnsafePointer() == oldVal.UnsafePointer() {
-				panic(fmt.Sprintf(
-					"Insert() of the same object (%T) back into the table. Is the immutable object being mutated?",
-					data))
-			}
+	revert := func() {
+		if oldExists {
+			idIndexTxn.Insert(idKey, oldObj)
+		} else {
+			idIndexTxn.Delete(idKey)
 		}
+		table.revision = oldRevision
 	}
 
 	// For CompareAndSwap() validate against the given guard revision
 	if guardRevision > 0 {
 		if !oldExists {
-			// CompareAndSwap requires the object to exist. Revert
-			// the insert.
-			idIndexTxn.Delete(idKey)
-			table.revision = oldRevision
+			// CompareAndSwap requires the object to exist.
+			revert()
 			return object{}, false, ErrObjectNotFound
 		}
 		if oldObj.revision != guardRevision {
-			// Revert the change. We're assuming here that it's rarer for CompareAndSwap() to
-			// fail and thus we're optimizing to have only one lookup in the common case
-			// (versus doing a Get() and then Insert()).
-			idIndexTxn.Insert(idKey, oldObj)
-			table.revision = oldRevision
+			revert()
 			return oldObj, true, ErrRevisionNotEqual
 		}
 	}
 
+	// For Modify() merge the new object with the old one returned by the
+	// insert and point the leaf to the merged object. The path to the object
+	// was cloned by the insert above and is reused.
+	if merge != nil {
+		merged, err := merge(oldObj.data, oldExists)
+		if err != nil {
+			revert()
+			return object{}, false, err
+		}
+		if oldExists && (isSameObject(oldObj.data, merged) || (equal != nil && equal(oldObj.data, merged))) {
+			// Nothing changed. The old object keeps its revision.
+			revert()
+			return oldObj, true, nil
+		}
+		obj.data = merged
+		if !meta.primary().fromObject(obj).First().Equal(idKey) {
+			panic(fmt.Sprintf(
+				"Modify() merged object (%T) has a different primary key than the object being modified",
+				merged))
+		}
+		idIndexTxn.Insert(idKey, obj)
+	}
+
+	if oldExists {
+		checkNotSameObject(oldObj.data, obj.data)
+	}
+
+	if err := txn.checkUnique(meta, table, idKey, obj); err != nil {
+		revert()
+		return object{}, false, err
+	}
+
+	if !oldExists {
+		// Make room for the new object if the table is at its capacity. The
+		// evicted object is deleted with the next revision and thus the object
+		// is given a revision after it to keep the changes in revision order.
+		evicted, err := txn.ensureCapacity(meta, table, idKey)
+		if err != nil {
+			revert()
+			return object{}, false, err
+		}
+		if evicted {
+			table.revision++
+			obj.revision = table.revision
+			idIndexTxn.Insert(idKey, obj)
+		}
+		table.objectCount++
+	}
+
 	// Update revision index
 	revIndexTxn := txn.mustIndexWriteTxn(meta, RevisionIndexPos)
 	if oldExists {
@@ -199,14 +347,14 @@ func (txn *txn) Insert(meta TableMeta, guardRevision Revision, data any) (object
 
 	// If it's new, possibly remove an older deleted object with the same
 	// primary key from the graveyard.
-	if !oldExists && txn.hasDeleteTrackers(meta) {
+	if !oldExists && (txn.hasDeleteTrackers(meta) || table.hasDeletedObjects()) {
 		if old, existed := txn.mustIndexWriteTxn(meta, GraveyardIndexPos).Delete(idKey); existed {
 			txn.mustIndexWriteTxn(meta, GraveyardRevisionIndexPos).Delete([]byte(index.Uint64(old.revision)))
 		}
 	}
 
 	// Then update secondary indexes
-	for _, indexer := range meta.secondary() {
+	for _, indexer := range table.secondary {
 		indexTxn := txn.mustIndexWriteTxn(meta, indexer.pos)
 		newKeys := indexer.fromObject(obj)
 
@@ -233,9 +381,64 @@ func (txn *txn) Insert(meta TableMeta, guardRevision Revision, data any) (object
 		})
 	}
 
+	txn.recordChange(change{
+		meta:   meta,
+		old:    oldObj,
+		hadOld: oldExists,
+		obj:    obj,
+	})
+
 	return oldObj, oldExists, nil
 }
 
+// recordChange counts the insert or delete and records it if it is needed
+// on commit.
+func (txn *txn) recordChange(c change) {
+	txn.numChanges++
+	if txn.recordChanges {
+		txn.changes = append(txn.changes, c)
+	}
+}
+
+// checkNotSameObject is a sanity check against inserting the same object back
+// into the table, which means the immutable object is being mutated.
+func checkNotSameObject(old, new any) {
+	if isSameObject(old, new) {
+		panic(fmt.Sprintf(
+			"Insert() of the same object (%T) back into the table. Is the immutable object being mutated?",
+			new))
+	}
+}
+
+// isSameObject returns true if both are pointers to the same object.
+func isSameObject(old, new any) bool {
+	val := reflect.ValueOf(new)
+	return val.Kind() == reflect.Pointer && val.UnsafePointer() == reflect.ValueOf(old).UnsafePointer()
+}
+
+// checkUnique checks that the keys of the object in the unique secondary
+// indexes are not in use by other objects.
+func (txn *txn) checkUnique(meta TableMeta, table *tableEntry, idKey index.Key, obj object) (err error) {
+	for _, indexer := range table.secondary {
+		if !indexer.unique {
+			continue
+		}
+		indexTxn := txn.mustIndexWriteTxn(meta, indexer.pos)
+		indexer.fromObject(obj).Foreach(func(key index.Key) {
+			if err != nil {
+				return
+			}
+			if other, found := indexTxn.Get(key); found && !meta.primary().fromObject(other).First().Equal(idKey) {
+				err = uniqueConstraintError(meta.Name(), indexer.name, key)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (txn *txn) hasDeleteTrackers(meta TableMeta) bool {
 	table := txn.modifiedTables[meta.tablePos()]
 	if table != nil {
@@ -255,6 +458,117 @@ func (txn *txn) addDeleteTracker(meta TableMeta, trackerName string, dt deleteTr
 
 }
 
+// AddIndex adds a secondary index to the table and builds it from the
+// objects in the primary index.
+func (txn *txn) AddIndex(meta TableMeta, indexer anyIndexer) error {
+	if txn.db == nil {
+		return ErrTransactionClosed
+	}
+	tableName := meta.Name()
+	table := txn.modifiedTables[meta.tablePos()]
+	if table == nil {
+		return tableError(tableName, ErrTableNotLockedForWriting)
+	}
+	if strings.HasPrefix(indexer.name, reservedIndexPrefix) {
+		return tableError(tableName, fmt.Errorf("index %q: %w", indexer.name, ErrReservedPrefix))
+	}
+	if _, exists := table.indexPos(indexer.name); exists {
+		return tableError(tableName, fmt.Errorf("index %q: %w", indexer.name, ErrDuplicateIndex))
+	}
+
+	// Check that the existing objects do not violate the uniqueness of
+	// the index before building it.
+	primary := txn.mustIndexReadTxn(meta, PrimaryIndexPos)
+	if indexer.unique {
+		owners := map[string]string{} // index key to primary key
+		iter := primary.Root().Iterator()
+		for idKey, obj, ok := iter.Next(); ok; idKey, obj, ok = iter.Next() {
+			var err error
+			indexer.fromObject(obj).Foreach(func(key index.Key) {
+				if owner, exists := owners[string(key)]; exists && owner != string(idKey) && err == nil {
+					err = uniqueConstraintError(tableName, indexer.name, key)
+				}
+				owners[string(key)] = string(idKey)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Reuse the position of a dropped index if there is one. The dropped
+	// index is empty, but its watch channels are closed on commit.
+	used := make([]bool, len(table.indexes))
+	for _, other := range table.secondary {
+		used[other.pos] = true
+	}
+	indexer.pos = slices.Index(used[SecondaryIndexStartPos:], false)
+	if indexer.pos < 0 {
+		indexer.pos = len(table.indexes)
+		table.indexes = append(table.indexes, indexEntry{tree: iradix.New[object]()})
+	} else {
+		indexer.pos += SecondaryIndexStartPos
+	}
+	table.indexes[indexer.pos].unique = indexer.unique
+
+	iter := primary.Root().Iterator()
+	for idKey, obj, ok := iter.Next(); ok; idKey, obj, ok = iter.Next() {
+		txn.indexObject(meta, indexer, idKey, obj)
+	}
+
+	secondary := maps.Clone(table.secondary)
+	if secondary == nil {
+		secondary = map[string]anyIndexer{}
+	}
+	secondary[indexer.name] = indexer
+	table.secondary = secondary
+	return nil
+}
+
+// DropIndex removes a secondary index from the table.
+func (txn *txn) DropIndex(meta TableMeta, name IndexName) error {
+	if txn.db == nil {
+		return ErrTransactionClosed
+	}
+	tableName := meta.Name()
+	table := txn.modifiedTables[meta.tablePos()]
+	if table == nil {
+		return tableError(tableName, ErrTableNotLockedForWriting)
+	}
+	if strings.HasPrefix(name, reservedIndexPrefix) {
+		return tableError(tableName, fmt.Errorf("index %q: %w", name, ErrReservedPrefix))
+	}
+	indexer, ok := table.secondary[name]
+	if !ok {
+		return tableError(tableName, fmt.Errorf("index %q: %w", name, ErrIndexNotFound))
+	}
+	for _, fk := range txn.db.state.Load().foreignKeys {
+		if fk.child == meta && fk.childIndex.name == name {
+			return tableError(tableName, fmt.Errorf("index %q: foreign key %s: %w", name, fk, ErrIndexReferenced))
+		}
+	}
+
+	// Empty out the index to close the watch channels of the queries
+	// made against it.
+	txn.mustIndexWriteTxn(meta, indexer.pos).DeletePrefix(nil)
+
+	secondary := maps.Clone(table.secondary)
+	delete(secondary, name)
+	table.secondary = secondary
+	return nil
+}
+
+// indexObject inserts the object into the secondary index.
+func (txn *txn) indexObject(meta TableMeta, indexer anyIndexer, idKey index.Key, obj object) {
+	indexTxn := txn.mustIndexWriteTxn(meta, indexer.pos)
+	indexer.fromObject(obj).Foreach(func(key index.Key) {
+		if !indexer.unique {
+			key = encodeNonUniqueKey(idKey, key)
+		}
+		indexTxn.Insert(key, obj)
+	})
+}
+
 func (txn *txn) Delete(meta TableMeta, guardRevision Revision, data any) (object, bool, error) {
 	if txn.db == nil {
 		return object{}, false, ErrTransactionClosed
@@ -289,6 +603,7 @@ func (txn *txn) Delete(meta TableMeta, guardRevision Revision, data any) (object
 			return obj, true, ErrRevisionNotEqual
 		}
 	}
+	table.objectCount--
 
 	// Update revision index.
 	indexTree := txn.mustIndexWriteTxn(meta, RevisionIndexPos)
@@ -299,7 +614,7 @@ func (txn *txn) Delete(meta TableMeta, guardRevision Revision, data any) (object
 	}
 
 	// Then update secondary indexes.
-	for _, indexer := range meta.secondary() {
+	for _, indexer := range table.secondary {
 		indexer.fromObject(obj).Foreach(func(key index.Key) {
 			if !indexer.unique {
 				key = encodeNonUniqueKey(idKey, key)
@@ -308,6 +623,14 @@ func (txn *txn) Delete(meta TableMeta, guardRevision Revision, data any) (object
 		})
 	}
 
+	txn.recordChange(change{
+		meta:    meta,
+		deleted: true,
+		old:     obj,
+		hadOld:  true,
+		obj:     object{revision: revision, data: obj.data},
+	})
+
 	// And finally insert the object into the graveyard.
 	if txn.hasDeleteTrackers(meta) {
 		graveyardIndex := txn.mustIndexWriteTxn(meta, GraveyardIndexPos)
@@ -370,15 +693,97 @@ func (txn *txn) Abort() {
 	}
 
 	txn.smus.Unlock()
+	txn.db.watchdog.released(txn)
 	txn.db.metrics.WriteTxnDuration(
 		txn.handle,
 		txn.tableNames,
 		time.Since(txn.acquiredAt))
 
+	onAbort := txn.onAbort
 	*txn = zeroTxn
+
+	for _, fn := range onAbort {
+		fn()
+	}
 }
 
-func (txn *txn) Commit() {
+func (txn *txn) Savepoint() Savepoint {
+	if txn.db == nil {
+		return Savepoint{}
+	}
+
+	// Commit the index transactions into trees so that the savepoint can
+	// refer to immutable trees. The committed transactions are notified on
+	// Commit() as they track the channels that need to be closed.
+	state := savepointState{
+		tables: make([]*tableEntry, len(txn.modifiedTables)),
+	}
+	for pos, table := range txn.modifiedTables {
+		if table == nil {
+			continue
+		}
+		for i := range table.indexes {
+			if itxn := table.indexes[i].txn; itxn != nil {
+				table.indexes[i].tree = itxn.CommitOnly()
+				table.indexes[i].txn = nil
+				txn.txnToNotify = append(txn.txnToNotify, itxn)
+			}
+		}
+		tableCopy := *table
+		tableCopy.indexes = slices.Clone(table.indexes)
+		state.tables[pos] = &tableCopy
+	}
+	state.numToNotify = len(txn.txnToNotify)
+	state.numChanges = txn.numChanges
+	state.numRecorded = len(txn.changes)
+	state.numOnCommit = len(txn.onCommit)
+	state.numOnAbort = len(txn.onAbort)
+	txn.savepoints = append(txn.savepoints, state)
+	return Savepoint{txn, len(txn.savepoints) - 1}
+}
+
+func (txn *txn) RollbackTo(sp Savepoint) error {
+	if txn.db == nil {
+		return ErrTransactionClosed
+	}
+	if sp.txn != txn || sp.id >= len(txn.savepoints) {
+		return ErrInvalidSavepoint
+	}
+	state := txn.savepoints[sp.id]
+	for pos, table := range state.tables {
+		if table != nil {
+			// The index transactions since the savepoint are dropped without
+			// notifying as the changes made by them are discarded.
+			*txn.modifiedTables[pos] = *table
+			txn.modifiedTables[pos].indexes = slices.Clone(table.indexes)
+		}
+	}
+	txn.txnToNotify = txn.txnToNotify[:state.numToNotify]
+	txn.changes = txn.changes[:state.numRecorded]
+	txn.numChanges = state.numChanges
+	txn.onCommit = txn.onCommit[:state.numOnCommit]
+	txn.onAbort = txn.onAbort[:state.numOnAbort]
+	txn.savepoints = txn.savepoints[:sp.id+1]
+	return nil
+}
+
+func (txn *txn) CommitID() uint64 {
+	return txn.commitID
+}
+
+func (txn *txn) OnCommit(fn func(ReadTxn)) {
+	if txn.db != nil {
+		txn.onCommit = append(txn.onCommit, fn)
+	}
+}
+
+func (txn *txn) OnAbort(fn func()) {
+	if txn.db != nil {
+		txn.onAbort = append(txn.onAbort, fn)
+	}
+}
+
+func (txn *txn) Commit() error {
 	runtime.SetFinalizer(txn, nil)
 
 	// We operate here under the following properties:
@@ -402,15 +807,45 @@ func (txn *txn) Commit() {
 	// If db is nil, this transaction has already been committed or aborted, and
 	// thus there is nothing to do.
 	if txn.db == nil {
-		return
+		return nil
 	}
 
 	db := txn.db
 
+	// Check the foreign keys before committing anything as the cascading deletes
+	// modify the transaction. The tables not locked by this transaction are checked
+	// against their latest committed state, which requires holding the foreign key
+	// lock until the new root has been stored to not race with the commits to the
+	// related tables.
+	fkLocked := false
+	if txn.hasForeignKeys(txn.foreignKeys) {
+		db.fkMu.Lock()
+		fkLocked = true
+		if err := txn.checkForeignKeys(db.state.Load()); err != nil {
+			db.fkMu.Unlock()
+			txn.Abort()
+			return err
+		}
+	}
+
+	// Append the changes to the journal before they become visible. This is done
+	// while holding the table locks to append the changes in revision order. The
+	// transaction is aborted if the changes cannot be appended as the journal
+	// would not contain them.
+	if txn.journal != nil && len(txn.changes) > 0 {
+		if err := txn.journal.append(txn.changes); err != nil {
+			if fkLocked {
+				db.fkMu.Unlock()
+			}
+			txn.Abort()
+			return err
+		}
+	}
+
 	// Commit each individual changed index to each table.
 	// We don't notify yet (CommitOnly) as the root needs to be updated
 	// first as otherwise readers would wake up too early.
-	txnToNotify := []*iradix.Txn[object]{}
+	txnToNotify := txn.txnToNotify
 	for _, table := range txn.modifiedTables {
 		if table == nil {
 			continue
@@ -439,8 +874,8 @@ func (txn *txn) Commit() {
 	// Since the root may have changed since the pointer was last read in WriteTxn(),
 	// load it again and modify the latest version that we now have immobilised by
 	// the root lock.
-	root := *db.root.Load()
-	root = slices.Clone(root)
+	prevState := db.state.Load()
+	root := slices.Clone(prevState.root)
 
 	// Insert the modified tables into the root tree of tables.
 	for pos, table := range txn.modifiedTables {
@@ -450,26 +885,49 @@ func (txn *txn) Commit() {
 	}
 
 	// Commit the transaction to build the new root tree and then
-	// atomically store it.
-	db.root.Store(&root)
+	// atomically store it together with the next commit ID.
+	state := &dbState{
+		root:        root,
+		commitID:    prevState.commitID + 1,
+		committed:   make(chan struct{}),
+		foreignKeys: prevState.foreignKeys,
+	}
+	db.state.Store(state)
+	db.recordHistory(state, txn.numChanges)
 	db.mu.Unlock()
+	if fkLocked {
+		db.fkMu.Unlock()
+	}
 
 	// With the root pointer updated, we can now release the tables for the next write transaction.
 	txn.smus.Unlock()
+	db.watchdog.released(txn)
 
 	// Now that new root is committed, we can notify readers by closing the watch channels of
 	// mutated radix tree nodes in all changed indexes and on the root itself.
 	for _, txn := range txnToNotify {
 		txn.Notify()
 	}
+	close(prevState.committed)
 
 	txn.db.metrics.WriteTxnDuration(
 		txn.handle,
 		txn.tableNames,
 		time.Since(txn.acquiredAt))
 
+	onCommit := txn.onCommit
+
 	// Zero out the transaction to make it inert.
 	*txn = zeroTxn
+
+	// Finally invoke the commit hooks with the committed snapshot.
+	if len(onCommit) > 0 {
+		committed := newReadTxn(db, state)
+		for _, fn := range onCommit {
+			fn(committed)
+		}
+	}
+	return nil
 }
 
 // WriteJSON marshals out the whole database as JSON into the given writer.
@@ -478,6 +936,9 @@ func (txn *txn) WriteJSON(w io.Writer) error {
 	buf.WriteString("{\n")
 	first := true
 	for _, table := range txn.root {
+		if table.meta == nil {
+			continue
+		}
 		if !first {
 			buf.WriteString(",\n")
 		} else {
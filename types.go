@@ -4,6 +4,7 @@
 package statedb
 
 import (
+	"encoding/json"
 	"io"
 
 	iradix "github.com/hashicorp/go-immutable-radix/v2"
@@ -48,6 +49,14 @@ type Table[Obj any] interface {
 	// invalidated by a write to the table.
 	Get(ReadTxn, Query[Obj]) (Iterator[Obj], <-chan struct{})
 
+	// AllReverse is like All, but iterates the objects in descending order
+	// of the primary key.
+	AllReverse(ReadTxn) (Iterator[Obj], <-chan struct{})
+
+	// GetReverse is like Get, but iterates the objects in descending order
+	// of their keys.
+	GetReverse(ReadTxn, Query[Obj]) (Iterator[Obj], <-chan struct{})
+
 	// First returns the first matching object for the query.
 	First(ReadTxn, Query[Obj]) (obj Obj, rev Revision, found bool)
 
@@ -68,9 +77,27 @@ type Table[Obj any] interface {
 	// are not possible with a lower bound search.
 	LowerBound(ReadTxn, Query[Obj]) (iter Iterator[Obj], watch <-chan struct{})
 
+	// UpperBound returns an iterator for objects that have a key less or
+	// equal to the query in descending order, e.g. the newest objects first
+	// with ByRevision. For a non-unique index the objects with a secondary
+	// key equal to the query are included. As with LowerBound the returned
+	// watch channel is closed when anything in the table changes.
+	UpperBound(ReadTxn, Query[Obj]) (iter Iterator[Obj], watch <-chan struct{})
+
+	// Range returns an iterator for objects that have a key greater or
+	// equal to 'from' and less than 'to'. The queries must be on the same
+	// index. The returned watch channel is closed when the part of the
+	// index that holds the keys sharing the common prefix of 'from' and
+	// 'to' changes.
+	Range(txn ReadTxn, from, to Query[Obj]) (iter Iterator[Obj], watch <-chan struct{})
+
 	// Prefix searches the table by key prefix.
 	Prefix(ReadTxn, Query[Obj]) (iter Iterator[Obj], watch <-chan struct{})
 
+	// PrefixReverse is like Prefix, but iterates the objects in descending
+	// order of their keys.
+	PrefixReverse(ReadTxn, Query[Obj]) (iter Iterator[Obj], watch <-chan struct{})
+
 	// DeleteTracker creates a new delete tracker for the table.
 	//
 	// It starts tracking deletions performed against the table from the
@@ -109,6 +136,9 @@ type RWTable[Obj any] interface {
 	// replaced if there was one.
 	//
 	// Possible errors:
+	// - ErrInvalidObject: the object was rejected by a validator of the table
+	// - ErrUniqueConstraintViolation: another object has the same key in a unique secondary index
+	// - ErrTableFull: the table is at its capacity and no object could be evicted
 	// - ErrTableNotLockedForWriting: table was not locked for writing
 	// - ErrTransactionClosed: the write transaction already committed or aborted
 	//
@@ -116,12 +146,35 @@ type RWTable[Obj any] interface {
 	// revision.
 	Insert(WriteTxn, Obj) (oldObj Obj, hadOld bool, err error)
 
+	// Modify inserts the object, or if an object with the same primary key
+	// exists, the object returned by merge(old, new). This avoids the separate
+	// lookup of the existing object when updating it. The merge function must
+	// not mutate the old object and must return an object with the same
+	// primary key. Returns the object that was replaced if there was one.
+	// If merge returns the old object itself, or one equal to it in a table
+	// with an equality function, the old object keeps its revision. Unlike
+	// with Insert the watch channels of the object may still be closed.
+	//
+	// Possible errors are the same as with Insert.
+	Modify(txn WriteTxn, obj Obj, merge func(old, new Obj) Obj) (oldObj Obj, hadOld bool, err error)
+
+	// InsertMany inserts all objects from the iterator. This is equivalent
+	// to calling Insert for each object, but if the table is empty the
+	// objects are bulk loaded, which is considerably faster for large
+	// numbers of objects. On error the objects may have been partially
+	// inserted and the write transaction should be aborted.
+	//
+	// Possible errors are the same as with Insert.
+	InsertMany(WriteTxn, Iterator[Obj]) error
+
 	// CompareAndSwap compares the existing object's revision against the
 	// given revision and if equal it replaces the object.
 	//
 	// Possible errors:
 	// - ErrRevisionNotEqual: the object has mismatching revision
 	// - ErrObjectNotFound: object not found from the table
+	// - ErrInvalidObject: the object was rejected by a validator of the table
+	// - ErrUniqueConstraintViolation: another object has the same key in a unique secondary index
 	// - ErrTableNotLockedForWriting: table was not locked for writing
 	// - ErrTransactionClosed: the write transaction already committed or aborted
 	CompareAndSwap(WriteTxn, Revision, Obj) (oldObj Obj, hadOld bool, err error)
@@ -148,6 +201,16 @@ type RWTable[Obj any] interface {
 	// - ErrTransactionClosed: the write transaction already committed or aborted
 	DeleteAll(WriteTxn) error
 
+	// DeleteWhere deletes the objects matching the query and returns the
+	// number of deleted objects. Semantically the same as Get() + Delete().
+	// See Delete() for more information.
+	//
+	// Possible errors:
+	// - ErrIndexNotFound: the table does not have the queried index
+	// - ErrTableNotLockedForWriting: table was not locked for writing
+	// - ErrTransactionClosed: the write transaction already committed or aborted
+	DeleteWhere(WriteTxn, Query[Obj]) (numDeleted int, err error)
+
 	// CompareAndDelete compares the existing object's revision against the
 	// given revision and if equal it deletes the object. If object is not
 	// found 'hadOld' will be false and 'err' nil.
@@ -157,6 +220,52 @@ type RWTable[Obj any] interface {
 	// - ErrTableNotLockedForWriting: table was not locked for writing
 	// - ErrTransactionClosed: the write transaction already committed or aborted
 	CompareAndDelete(WriteTxn, Revision, Obj) (oldObj Obj, hadOld bool, err error)
+
+	// Changes returns an iterator for the objects inserted, replaced or
+	// deleted in the table by the write transaction so far. The changes
+	// are coalesced by primary key: the old object is the one prior to the
+	// transaction and the new object is the latest one. An object that was
+	// both inserted and deleted by the transaction is not included. The
+	// changes are iterated in the order of the primary keys.
+	Changes(WriteTxn) Iterator[Change[Obj]]
+
+	// AddIndex adds a secondary index to the table. The index is built
+	// from the objects in the table and can be queried with the write
+	// transaction right away and by readers once it has been committed.
+	// Read transactions created prior to the commit do not have the index.
+	//
+	// Possible errors:
+	// - ErrDuplicateIndex: the table already has an index with the same name
+	// - ErrReservedPrefix: the index name uses the reserved prefix
+	// - ErrUniqueConstraintViolation: the index is unique and the existing objects have duplicate keys
+	// - ErrTableNotLockedForWriting: table was not locked for writing
+	// - ErrTransactionClosed: the write transaction already committed or aborted
+	AddIndex(WriteTxn, Indexer[Obj]) error
+
+	// DropIndex removes a secondary index from the table. Queries against
+	// the dropped index panic once the index has been dropped and the
+	// watch channels returned by the prior queries are closed on commit.
+	//
+	// Possible errors:
+	// - ErrIndexNotFound: the table has no secondary index with the name
+	// - ErrIndexReferenced: the index is used by a foreign key
+	// - ErrTableNotLockedForWriting: table was not locked for writing
+	// - ErrTransactionClosed: the write transaction already committed or aborted
+	DropIndex(WriteTxn, IndexName) error
+}
+
+// Change describes how an object was changed by a write transaction.
+// See RWTable.Changes.
+type Change[Obj any] struct {
+	// Old is the object prior to the transaction. Only valid if HadOld
+	// is true.
+	Old    Obj
+	HadOld bool
+
+	// New is the object after the transaction. Only valid if Deleted
+	// is false.
+	New     Obj
+	Deleted bool
 }
 
 // TableMeta provides information about the table that is independent of
@@ -169,8 +278,11 @@ type TableMeta interface {
 	indexPos(string) int
 	tableKey() []byte                      // The radix key for the table in the root tree
 	primary() anyIndexer                   // The untyped primary indexer for the table
-	secondary() map[string]anyIndexer      // Secondary indexers (if any)
 	sortableMutex() internal.SortableMutex // The sortable mutex for locking the table for writing
+	encodeObject(any) ([]byte, error)      // Encode the object with the table's codec
+	decodeObject([]byte) (any, error)      // Decode the object with the table's codec
+	capacity() (int, EvictionPolicy)       // The maximum number of objects and the eviction policy
+	equalFunc() func(a, b any) bool        // The function for comparing objects, or nil
 }
 
 // Iterator for iterating objects returned from queries.
@@ -185,6 +297,13 @@ type ReadTxn interface {
 
 	// WriteJSON writes the contents of the database as JSON.
 	WriteJSON(io.Writer) error
+
+	// CommitID returns the ID of the commit that produced the snapshot of
+	// the database the transaction reads from. The commit ID is incremented
+	// by each committed write transaction and thus a read transaction with
+	// a higher commit ID is newer than one with a lower ID. A write
+	// transaction returns the commit ID of the snapshot it was created from.
+	CommitID() uint64
 }
 
 type WriteTxn interface {
@@ -204,7 +323,47 @@ type WriteTxn interface {
 
 	// Commit the changes in the current transaction to the target tables.
 	// This is a no-op if Abort() or Commit() has already been called.
-	Commit()
+	//
+	// Commit fails with ErrForeignKeyViolation if the changes violate the
+	// foreign keys between the tables (see AddForeignKey). The transaction is
+	// then aborted.
+	Commit() error
+
+	// OnCommit registers a function to call after the transaction has been
+	// committed and the readers have been notified. The function is given
+	// the snapshot of the database as it was right after the commit. The
+	// table locks have been released when the function is called, so it may
+	// create new write transactions.
+	//
+	// The functions are called in the order they were registered. Has no effect
+	// if the transaction has already been committed or aborted.
+	OnCommit(func(ReadTxn))
+
+	// OnAbort registers a function to call after the transaction has been
+	// aborted. See OnCommit().
+	OnAbort(func())
+
+	// Savepoint marks the current state of the transaction. The changes
+	// made after it can be discarded with RollbackTo() without aborting the
+	// whole transaction and releasing the table locks.
+	Savepoint() Savepoint
+
+	// RollbackTo discards the changes made after the savepoint was created,
+	// including table revisions and the OnCommit and OnAbort functions that
+	// were registered. The savepoint remains valid and can be rolled back
+	// to again, but savepoints created after it are invalidated.
+	//
+	// Possible errors:
+	// - ErrInvalidSavepoint: the savepoint is not from this transaction or has been invalidated
+	// - ErrTransactionClosed: the write transaction already committed or aborted
+	RollbackTo(Savepoint) error
+}
+
+// Savepoint is a marker within a write transaction to which it can be
+// rolled back to. See WriteTxn.Savepoint.
+type Savepoint struct {
+	txn *txn
+	id  int
 }
 
 type Query[Obj any] struct {
@@ -278,6 +437,26 @@ type Indexer[Obj any] interface {
 	QueryFromObject(Obj) Query[Obj]
 }
 
+// Codec encodes and decodes the objects of a table. Used for persisting the
+// database with DB.Snapshot and DB.Restore. See WithCodec.
+type Codec[Obj any] interface {
+	Encode(obj Obj) ([]byte, error)
+	Decode(data []byte) (Obj, error)
+}
+
+// JSONCodec encodes and decodes objects as JSON. This is the default codec
+// for tables.
+type JSONCodec[Obj any] struct{}
+
+func (JSONCodec[Obj]) Encode(obj Obj) ([]byte, error) {
+	return json.Marshal(obj)
+}
+
+func (JSONCodec[Obj]) Decode(data []byte) (obj Obj, err error) {
+	err = json.Unmarshal(data, &obj)
+	return
+}
+
 // TableWritable is a constraint for objects that implement tabular
 // pretty-printing. Used in "cilium-dbg statedb" sub-commands.
 type TableWritable interface {
@@ -304,6 +483,10 @@ const (
 	GraveyardRevisionIndex    = "__graveyard_revision__"
 	GraveyardRevisionIndexPos = 3
 
+	// ExpiryIndex is the secondary index of the objects by their expiry time
+	// in tables created with WithExpiry.
+	ExpiryIndex = "__expiry__"
+
 	SecondaryIndexStartPos = 4
 )
 
@@ -349,6 +532,29 @@ type tableEntry struct {
 	deleteTrackers *iradix.Tree[deleteTracker]
 	revision       uint64
 	initializers   int // Number of table initializers pending
+	objectCount    int // Number of objects, kept up to date in write transactions
+
+	// secondary are the secondary indexers of the table. The map is not
+	// modified in place, but replaced when an index is added or dropped.
+	secondary map[string]anyIndexer
+}
+
+// indexPos returns the position of the named index in [tableEntry.indexes].
+func (t *tableEntry) indexPos(name IndexName) (int, bool) {
+	if indexer, ok := t.secondary[name]; ok {
+		return indexer.pos, true
+	}
+	switch name {
+	case t.meta.primary().name:
+		return PrimaryIndexPos, true
+	case RevisionIndex:
+		return RevisionIndexPos, true
+	case GraveyardIndex:
+		return GraveyardIndexPos, true
+	case GraveyardRevisionIndex:
+		return GraveyardRevisionIndexPos, true
+	}
+	return 0, false
 }
 
 func (t *tableEntry) numObjects() int {
@@ -360,3 +566,11 @@ func (t *tableEntry) numDeletedObjects() int {
 	indexEntry := t.indexes[t.meta.indexPos(GraveyardIndex)]
 	return indexEntry.tree.Len()
 }
+
+// hasDeletedObjects returns true if the graveyard may contain objects. The
+// graveyard may be non-empty even without delete trackers, e.g. after the
+// last tracker was closed or after the table was restored from a snapshot.
+func (t *tableEntry) hasDeletedObjects() bool {
+	indexEntry := t.indexes[GraveyardIndexPos]
+	return indexEntry.txn != nil || indexEntry.tree.Len() > 0
+}
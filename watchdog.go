@@ -0,0 +1,127 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package statedb
+
+import (
+	"context"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// lockWatchdog keeps track of the write transactions holding table locks
+// and reports the ones that have held them for longer than the threshold.
+type lockWatchdog struct {
+	threshold time.Duration
+	logger    *slog.Logger
+	exited    chan struct{}
+
+	mu     sync.Mutex
+	lastID uint64
+
+	// holders are keyed by the watchdog IDs of the write transactions rather
+	// than by the transactions to not keep a leaked transaction alive, which
+	// would prevent txnFinalizer from catching it.
+	holders map[uint64]*lockHolder
+}
+
+type lockHolder struct {
+	handle     string
+	tables     []string
+	acquiredAt time.Time
+	stack      []byte // stack of the goroutine that called WriteTxn()
+	reported   bool
+}
+
+// SetLockWatchdog enables reporting of write transactions that hold the table
+// locks for longer than 'threshold'. The report is logged with the given logger
+// (or slog.Default() if nil) and includes the handle name, the locked tables
+// and the goroutine stack trace captured when the write transaction was
+// created. The reports are also counted with LockWatchdogMetrics.WriteTxnLongHeld.
+// Each write transaction is reported once.
+//
+// Capturing the stack traces adds overhead to WriteTxn(). Must be called
+// before the database is started.
+func (db *DB) SetLockWatchdog(threshold time.Duration, logger *slog.Logger) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	db.watchdog.threshold = threshold
+	db.watchdog.logger = logger
+	db.watchdog.holders = map[uint64]*lockHolder{}
+}
+
+func (w *lockWatchdog) enabled() bool {
+	return w.threshold > 0
+}
+
+// acquired is called when the write transaction has acquired the table locks.
+func (w *lockWatchdog) acquired(txn *txn) {
+	if !w.enabled() {
+		return
+	}
+	holder := &lockHolder{
+		handle:     txn.handle,
+		tables:     txn.tableNames,
+		acquiredAt: txn.acquiredAt,
+		stack:      debug.Stack(),
+	}
+	w.mu.Lock()
+	w.lastID++
+	txn.watchdogID = w.lastID
+	w.holders[txn.watchdogID] = holder
+	w.mu.Unlock()
+}
+
+// released is called when the write transaction has released the table locks
+// or has been found leaked by txnFinalizer.
+func (w *lockWatchdog) released(txn *txn) {
+	if !w.enabled() {
+		return
+	}
+	w.mu.Lock()
+	delete(w.holders, txn.watchdogID)
+	w.mu.Unlock()
+}
+
+// check reports the write transactions that have held the locks for too long.
+func (w *lockWatchdog) check(metrics LockWatchdogMetrics, now time.Time) {
+	var overdue []*lockHolder
+	w.mu.Lock()
+	for _, holder := range w.holders {
+		if !holder.reported && now.Sub(holder.acquiredAt) > w.threshold {
+			holder.reported = true
+			overdue = append(overdue, holder)
+		}
+	}
+	w.mu.Unlock()
+
+	for _, holder := range overdue {
+		held := now.Sub(holder.acquiredAt)
+		metrics.WriteTxnLongHeld(holder.handle, holder.tables, held)
+		w.logger.Warn("Write transaction has held table locks for too long",
+			"handle", holder.handle,
+			"tables", holder.tables,
+			"held", held,
+			"threshold", w.threshold,
+			"stack", string(holder.stack))
+	}
+}
+
+func lockWatchdogWorker(db *DB, ctx context.Context) {
+	w := &db.watchdog
+	defer close(w.exited)
+
+	ticker := time.NewTicker(max(w.threshold/2, time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			w.check(db.metrics, now)
+		}
+	}
+}
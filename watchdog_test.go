@@ -0,0 +1,48 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package statedb
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_LockWatchdog(t *testing.T) {
+	t.Parallel()
+
+	metrics := NewExpVarMetrics(false)
+	table := MustNewTable[testObject]("test", idIndex)
+	db, err := NewDB([]TableMeta{table}, metrics)
+	require.NoError(t, err)
+
+	var logBuf bytes.Buffer
+	db.SetLockWatchdog(10*time.Millisecond, slog.New(slog.NewTextHandler(&logBuf, nil)))
+	require.NoError(t, db.Start(context.Background()))
+
+	// A short write transaction is not reported.
+	wtxn := db.WriteTxn(table)
+	wtxn.Commit()
+
+	wtxn = db.NewHandle("slow").WriteTxn(table)
+	require.Eventually(t,
+		func() bool { return metrics.WriteTxnLongHeldVar.Get("slow/test") != nil },
+		time.Second, 5*time.Millisecond)
+	wtxn.Abort()
+	require.NoError(t, db.Stop(context.Background()))
+	require.Empty(t, db.watchdog.holders)
+
+	require.Equal(t, "1", metrics.WriteTxnLongHeldVar.Get("slow/test").String())
+	require.Nil(t, metrics.WriteTxnLongHeldVar.Get("DB/test"))
+
+	log := logBuf.String()
+	require.Contains(t, log, "Write transaction has held table locks for too long")
+	require.Contains(t, log, "handle=slow")
+	require.Contains(t, log, "tables=[test]")
+	require.Contains(t, log, "TestDB_LockWatchdog", "expected the stack trace of WriteTxn() caller")
+}